@@ -3,50 +3,142 @@ package analyzer
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	redigo "github.com/gomodule/redigo/redis"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/tree"
 )
 
 type Analyzer struct {
-	Host       string
-	Port       uint `json:"port"`
-	Password   string
-	Count      uint   `json:"count"`
-	Limit      uint64 `json:"limit"`
-	Match      string
-	Types      string
-	Separators string
-	Cluster    bool
-	Pause      time.Duration `json:"pause"` // ms
+	filteredKeyNum int64     // keys dropped by Include and Exclude, keep first for 64-bit atomic alignment
+	origin         *Analyzer // the analyzer which counts for backends
+	progress       *progress
+	interrupt      *interruption
+	detectedRules  []tree.Rule // rules of detected separators, which may have ';' that Rules can not express
+
+	Host           string
+	Port           uint `json:"port"`
+	Password       string
+	Count          uint   `json:"count"`
+	Limit          uint64 `json:"limit"` // max keys read, of all backends
+	Match          string
+	Include        []string `json:"include"` // client side filter after SCAN, glob or regexp with RegexpPrefix
+	Exclude        []string `json:"exclude"`
+	Types          string
+	Separators     string        // every byte is a separator
+	Rules          string        `json:"separator_rules"` // separator rules by depth, override Separators, see tree.ParseRules
+	AutoSeparators bool          `json:"auto_separators"` // detect separators if both Separators and Rules are empty, or Separators is SeparatorsAuto
+	MaxDepth       int           `json:"max_depth"`       // aggregate keys deeper than it into their prefix, 0 for no limit
+	FoldSize       int64         `json:"fold_size"`       // fold children smaller than it into tree.OtherSegment
+	FoldKeyNum     int64         `json:"fold_key_num"`    // fold children with less keys than it into tree.OtherSegment
+	Cluster        bool          // decide SizeMode if it is not set, estimate for cluster and memory-usage for others
+	SizeMode       string        `json:"size_mode"`       // one of SizeModeMemoryUsage, SizeModeEstimate, SizeModeExact, SizeModeEncodingAware
+	MemorySamples  int           `json:"memory_samples"`  // SAMPLES of MEMORY USAGE, redis default if 0, all elements if negative
+	ElementSample  uint          `json:"element_sample"`  // elements sampled per collection, DefaultElementSample by default
+	ExactThreshold uint          `json:"exact_threshold"` // read all elements of collections not longer than it
+	Coefficients   string        `json:"coefficients"`    // file of coefficients fitted by Calibrate
+	Pause          time.Duration `json:"pause"`           // ms
+	Sample         uint64        `json:"sample"`          // sample keys by RANDOMKEY instead of SCAN if not 0
+	Confidence     float64       `json:"confidence"`      // confidence level of sampling estimates, 0.95 by default
+	Escape         string        `json:"escape"`          // escape mode of keys in outputs, see escape.Mode
+	TTL            bool          `json:"ttl"`             // collect TTL of keys by PTTL for TTL stats
+	BigKeyNum      int           `json:"big_key_num"`     // biggest keys kept for every type, DefaultBigKeyNum if 0, none if negative
+
+	Backends        []string `json:"backends"`         // redis servers behind proxy to scan directly, host:port
+	BackendPassword string   `json:"backend_password"` // password of backends, Password by default
+	Discover        string   `json:"discover"`         // discover backends from proxy admin, see DiscoverCodis
+	KeyFile         string   `json:"key_file"`         // read keys from file instead of SCAN, one key per line
 }
 
 func (a *Analyzer) Run() *KeyTypeTree {
-	tree := NewKeyTypeTree([]byte(a.Separators))
-	keysChan := make(chan []string, 10)
-
-	wg := &sync.WaitGroup{}
-	wg.Add(2)
-	go a.scan(keysChan, wg)
-	go a.analysisKey(keysChan, tree, wg)
+	tree, wg := a.AsyncRun()
 	wg.Wait()
 	return tree
 }
 
 func (a *Analyzer) AsyncRun() (*KeyTypeTree, *sync.WaitGroup) {
-	tree := NewKeyTypeTree([]byte(a.Separators))
-	keysChan := make(chan []string, 10)
-
+	// every source has its own pipeline, and all of them update the same tree
+	sources, err := a.sources()
+	errorJudge("prepare sources", err)
+	a.detectSeparatorsIfNeed(sources)
+	tree := NewKeyTypeTree(a.SeparatorRules())
+	tree.SetMaxDepth(a.MaxDepth)
+	tree.SetFold(a.FoldSize, a.FoldKeyNum)
+	tree.SetSource(a.SourceName())
+	tree.SetBigKeyNum(a.BigKeyNum)
 	wg := &sync.WaitGroup{}
-	wg.Add(2)
-	go a.scan(keysChan, wg)
-	go a.analysisKey(keysChan, tree, wg)
+	a.counter().startProgress(wg)
+	a.counter().interruption()
+
+	sizeChans := make([]chan []*KeyInfo, 0, len(sources))
+	for _, source := range sources {
+		keysChan := make(chan []*KeyInfo, 10)
+		wg.Add(1)
+		switch {
+		case source.KeyFile != "":
+			go source.readKeyFile(keysChan, wg)
+		case source.Sample > 0:
+			go source.sample(keysChan, tree, wg)
+		default:
+			go source.scan(keysChan, wg)
+		}
+		sizeChans = append(sizeChans, source.analysisKey(keysChan, wg))
+	}
+	wg.Add(1)
+	go a.updateTree(mergeKeyInfo(sizeChans), tree, wg)
 	return tree, wg
 }
 
 func (a *Analyzer) Dial() redigo.Conn {
-	address := fmt.Sprintf("%s:%d", a.Host, a.Port)
-	conn, err := redigo.Dial("tcp", address, redigo.DialPassword(a.Password))
+	conn, err := a.dial()
 	errorJudge("dial redis", err)
 	return conn
 }
+
+func (a *Analyzer) dial() (redigo.Conn, error) {
+	address := fmt.Sprintf("%s:%d", a.Host, a.Port)
+	return redigo.Dial("tcp", address, redigo.DialPassword(a.Password))
+}
+
+// SeparatorRules return detected rules, or rules parsed from Rules, or rule of Separators if Rules is not set
+func (a *Analyzer) SeparatorRules() []tree.Rule {
+	if a.detectedRules != nil {
+		return a.detectedRules
+	}
+	if a.Rules == "" {
+		return tree.ByteRules([]byte(a.Separators))
+	}
+	rules, err := tree.ParseRules(a.Rules)
+	errorJudge("parse separator rules", err)
+	return rules
+}
+
+// SourceName name keys analyzed by a, which are attributed to it in merged trees
+func (a *Analyzer) SourceName() string {
+	if a.KeyFile != "" {
+		return a.KeyFile
+	}
+	return fmt.Sprintf("%s:%d", a.Host, a.Port)
+}
+
+// EscapeMode return the mode escaping keys in outputs, escape.Auto by default
+func (a *Analyzer) EscapeMode() escape.Mode {
+	mode, err := escape.Parse(a.Escape)
+	errorJudge("parse escape mode", err)
+	return mode
+}
+
+// FilteredKeyNum return the number of scanned keys dropped by Include and Exclude
+func (a *Analyzer) FilteredKeyNum() int64 {
+	return atomic.LoadInt64(&a.counter().filteredKeyNum)
+}
+
+// counter return the analyzer counting for all backends
+func (a *Analyzer) counter() *Analyzer {
+	if a.origin != nil {
+		return a.origin
+	}
+	return a
+}
@@ -0,0 +1,261 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// Coefficients scale estimated size of keys, fitted by Calibrate for a size mode
+type Coefficients struct {
+	Mode         string             `json:"mode"`
+	Coefficients map[string]float64 `json:"coefficients"` // by type, or by type and encoding like "hash:listpack"
+}
+
+func LoadCoefficients(path string) (*Coefficients, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Coefficients{}
+	if err = json.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("parse coefficients %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// loadCoefficientsOfMode return nil if coefficients are fitted for another size mode
+func loadCoefficientsOfMode(path, mode string) (*Coefficients, error) {
+	c, err := LoadCoefficients(path)
+	if err != nil {
+		return nil, err
+	}
+	if c.Mode != mode {
+		log.Printf("coefficients fitted for %s size mode, ignored in %s size mode\n", c.Mode, mode)
+		return nil, nil
+	}
+	return c, nil
+}
+
+func (c *Coefficients) Save(path string) error {
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// Apply scale size by the coefficient of type and encoding, or type only if encoding is not fitted
+func (c *Coefficients) Apply(info *KeyInfo, size int64) int64 {
+	if c == nil {
+		return size
+	}
+	typeStr := KeyTypeToTypeStr[info.KeyT]
+	coefficient, ok := c.Coefficients[typeStr+":"+info.Encoding]
+	if !ok || info.Encoding == "" {
+		coefficient, ok = c.Coefficients[typeStr]
+	}
+	if !ok {
+		return size
+	}
+	return int64(float64(size)*coefficient + 0.5)
+}
+
+// Calibration compare estimated size with MEMORY USAGE ... SAMPLES 0 by type and encoding
+type Calibration struct {
+	Mode   string
+	Groups []*CalibrationGroup // sorted by type and encoding
+}
+
+// CalibrationGroup relative error (estimate - actual) / actual of keys with the same type and encoding
+type CalibrationGroup struct {
+	Type        string
+	Encoding    string
+	KeyNum      int
+	Bias        float64 // mean of relative error
+	MeanError   float64 // mean of absolute relative error
+	MedianError float64
+	P90Error    float64
+	P99Error    float64
+	MaxError    float64
+	Coefficient float64 // scale estimate by it to minimize squared relative error
+
+	ratios []float64 // estimate / actual
+}
+
+// Calibrate sample at most keysPerGroup keys per type and encoding from SCAN, estimate their size in SizeMode
+// (estimate if it is memory-usage) and compare with MEMORY USAGE, Limit bound the keys scanned
+func (a *Analyzer) Calibrate(keysPerGroup int) *Calibration {
+	conn := a.Dial()
+	defer conn.Close()
+
+	mode := a.GetSizeMode()
+	if mode == SizeModeMemoryUsage {
+		mode = SizeModeEstimate
+	}
+	calibrator := *a
+	calibrator.Coefficients = "" // fit raw estimates
+	calibrator.MemorySamples = -1
+	sampler := calibrator.newElementSampler(conn, mode)
+	filter, err := newKeyFilter(a.Include, a.Exclude)
+	errorJudge("parse key filter", err)
+	types := a.keyTypes()
+
+	groups := make(map[string]*CalibrationGroup)
+	var (
+		cursor  int
+		scanned uint64
+	)
+	for {
+		results, err := redigo.Values(conn.Do("SCAN", cursor, "MATCH", a.Match, "COUNT", a.Count))
+		errorJudge("scan redis", err)
+		cursor, _ = redigo.Int(results[0], nil)
+		keys, _ := redigo.Strings(results[1], nil)
+		scanned += uint64(len(keys))
+
+		for _, key := range keys {
+			err := conn.Send("TYPE", key)
+			errorJudge("redis conn Send TYPE cmd", err)
+			err = conn.Send("OBJECT", "ENCODING", key)
+			errorJudge("redis conn Send OBJECT ENCODING cmd", err)
+		}
+		err = conn.Flush()
+		errorJudge("redis conn Flush", err)
+		var infos []*KeyInfo
+		for _, key := range keys {
+			typeStr, err := redigo.String(conn.Receive())
+			errorJudge("redis conn Receive", err)
+			encoding, err := redigo.String(conn.Receive())
+			if err != nil { // rejected by server, or key deleted meanwhile
+				encoding = "unknown"
+			}
+			keyT, ok := types[typeStr]
+			if !ok || (!filter.empty() && !filter.Match(key)) {
+				continue
+			}
+			group, ok := groups[typeStr+":"+encoding]
+			if !ok {
+				group = &CalibrationGroup{Type: typeStr, Encoding: encoding}
+				groups[typeStr+":"+encoding] = group
+			}
+			if group.KeyNum >= keysPerGroup {
+				continue
+			}
+			group.KeyNum++
+			infos = append(infos, &KeyInfo{Key: key, KeyT: keyT, Encoding: encoding})
+		}
+
+		if len(infos) > 0 {
+			actuals := make([]*KeyInfo, 0, len(infos))
+			for _, info := range infos {
+				actuals = append(actuals, &KeyInfo{Key: info.Key, KeyT: info.KeyT, Encoding: info.Encoding})
+			}
+			errorJudge("calibrate need MEMORY USAGE", calibrator.memoryUsage(conn, actuals))
+			sampler.estimate(conn, infos)
+			for i, info := range infos {
+				group := groups[KeyTypeToTypeStr[info.KeyT]+":"+actuals[i].Encoding]
+				if actuals[i].Size <= 0 {
+					group.KeyNum--
+					continue
+				}
+				group.ratios = append(group.ratios, float64(info.Size)/float64(actuals[i].Size))
+			}
+			time.Sleep(a.Pause * time.Millisecond)
+		}
+		if cursor == 0 || scanned >= a.Limit {
+			break
+		}
+	}
+	log.Printf("calibrate finish, scanned %d keys\n", scanned)
+
+	c := &Calibration{Mode: mode}
+	for _, group := range groups {
+		if len(group.ratios) == 0 {
+			continue
+		}
+		group.summarize()
+		c.Groups = append(c.Groups, group)
+	}
+	sort.Slice(c.Groups, func(i, j int) bool {
+		if c.Groups[i].Type != c.Groups[j].Type {
+			return KeyTypeStrToType[c.Groups[i].Type] < KeyTypeStrToType[c.Groups[j].Type]
+		}
+		return c.Groups[i].Encoding < c.Groups[j].Encoding
+	})
+	return c
+}
+
+func (g *CalibrationGroup) summarize() {
+	errs := make([]float64, 0, len(g.ratios))
+	var sum, absSum float64
+	for _, ratio := range g.ratios {
+		sum += ratio - 1
+		absSum += math.Abs(ratio - 1)
+		errs = append(errs, math.Abs(ratio-1))
+	}
+	sort.Float64s(errs)
+	num := float64(len(errs))
+	g.KeyNum = len(errs)
+	g.Bias = sum / num
+	g.MeanError = absSum / num
+	g.MedianError = percentile(errs, 0.5)
+	g.P90Error = percentile(errs, 0.9)
+	g.P99Error = percentile(errs, 0.99)
+	g.MaxError = errs[len(errs)-1]
+	g.Coefficient = fitCoefficient(g.ratios)
+}
+
+// fitCoefficient return k minimizing sum of (k * estimate - actual)^2 / actual^2
+func fitCoefficient(ratios []float64) float64 {
+	var sum, squareSum float64
+	for _, ratio := range ratios {
+		sum += ratio
+		squareSum += ratio * ratio
+	}
+	if squareSum == 0 {
+		return 1
+	}
+	return sum / squareSum
+}
+
+// percentile of sorted values by nearest rank, p in [0, 1]
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Coefficients fit by type, and also by type and encoding in encoding aware size mode
+func (c *Calibration) Coefficients() *Coefficients {
+	coefficients := &Coefficients{Mode: c.Mode, Coefficients: make(map[string]float64)}
+	byType := make(map[string][]float64)
+	for _, group := range c.Groups {
+		byType[group.Type] = append(byType[group.Type], group.ratios...)
+		if c.Mode == SizeModeEncodingAware {
+			coefficients.Coefficients[group.Type+":"+group.Encoding] = group.Coefficient
+		}
+	}
+	for typeStr, ratios := range byType {
+		coefficients.Coefficients[typeStr] = fitCoefficient(ratios)
+	}
+	return coefficients
+}
+
+func (c *Calibration) Print() {
+	fmt.Printf("Calibration of %s size mode, relative error = (estimate - actual) / actual:\n", c.Mode)
+	fmt.Printf("%-8s %-12s %8s %8s %8s %8s %8s %8s %8s %12s\n",
+		"Type", "Encoding", "KeyNum", "Bias", "Mean", "Median", "P90", "P99", "Max", "Coefficient")
+	for _, g := range c.Groups {
+		fmt.Printf("%-8s %-12s %8d %+7.1f%% %7.1f%% %7.1f%% %7.1f%% %7.1f%% %7.1f%% %12.4f\n",
+			g.Type, g.Encoding, g.KeyNum, g.Bias*100, g.MeanError*100, g.MedianError*100,
+			g.P90Error*100, g.P99Error*100, g.MaxError*100, g.Coefficient)
+	}
+}
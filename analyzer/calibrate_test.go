@@ -0,0 +1,80 @@
+package analyzer
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestFitCoefficient(t *testing.T) {
+	for _, c := range []struct {
+		ratios []float64
+		want   float64
+	}{
+		{nil, 1},
+		{[]float64{0, 0}, 1},
+		{[]float64{2, 2, 2}, 0.5},
+		{[]float64{0.5, 1, 2}, 3.5 / 5.25},
+		{[]float64{1, 1, 1, 1, 10}, 14.0 / 104}, // an outlier dominates squared relative error
+	} {
+		if got := fitCoefficient(c.ratios); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("fitCoefficient(%v) expected %v, got %v", c.ratios, c.want, got)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for p, want := range map[float64]float64{0: 1, 0.01: 1, 0.1: 1, 0.11: 2, 0.5: 5, 0.9: 9, 0.99: 10, 1: 10} {
+		if got := percentile(sorted, p); got != want {
+			t.Errorf("percentile(%v) expected %v, got %v", p, want, got)
+		}
+	}
+	for _, p := range []float64{0, 0.5, 1} {
+		if got := percentile([]float64{3}, p); got != 3 {
+			t.Errorf("percentile(%v) of single value expected 3, got %v", p, got)
+		}
+	}
+}
+
+func TestCoefficientsApply(t *testing.T) {
+	var nilCoefficients *Coefficients
+	info := &KeyInfo{KeyT: KeyTypeHash, Encoding: "listpack"}
+	if got := nilCoefficients.Apply(info, 100); got != 100 {
+		t.Errorf("Expected nil coefficients keep size 100, got %d", got)
+	}
+	c := &Coefficients{Coefficients: map[string]float64{"hash": 2, "hash:listpack": 0.5}}
+	for _, tc := range []struct {
+		info *KeyInfo
+		want int64
+	}{
+		{info, 50},
+		{&KeyInfo{KeyT: KeyTypeHash, Encoding: "hashtable"}, 200}, // encoding not fitted
+		{&KeyInfo{KeyT: KeyTypeHash}, 200},
+		{&KeyInfo{KeyT: KeyTypeSet}, 100}, // type not fitted
+	} {
+		if got := c.Apply(tc.info, 100); got != tc.want {
+			t.Errorf("Apply(%+v) expected %d, got %d", tc.info, tc.want, got)
+		}
+	}
+}
+
+func TestLoadCoefficientsOfMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coefficients.json")
+	c := &Coefficients{Mode: SizeModeEstimate, Coefficients: map[string]float64{"hash": 1.5}}
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadCoefficientsOfMode(path, SizeModeEstimate)
+	if err != nil || loaded == nil || loaded.Coefficients["hash"] != 1.5 {
+		t.Errorf("Expected coefficients of estimate mode, got %+v, err:%v", loaded, err)
+	}
+	// coefficients of another size mode are ignored
+	loaded, err = loadCoefficientsOfMode(path, SizeModeEncodingAware)
+	if err != nil || loaded != nil {
+		t.Errorf("Expected coefficients ignored in encoding aware mode, got %+v, err:%v", loaded, err)
+	}
+	if _, err = loadCoefficientsOfMode(filepath.Join(t.TempDir(), "missing.json"), SizeModeEstimate); err == nil {
+		t.Errorf("Expected error of missing file")
+	}
+}
@@ -0,0 +1,206 @@
+package analyzer
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+// DetectSampleNum is the key num scanned to detect separators
+const DetectSampleNum = 1000
+
+// separatorStat collect statistics of a candidate separator byte
+type separatorStat struct {
+	keyNum   int             // keys containing it
+	edgeNum  int             // occurrences at the start or end of key
+	num      int             // all occurrences
+	runNum   map[int]int     // occurrences by run length, e.g. 2 for "::"
+	prefixes map[string]bool // distinct prefixes before its first occurrence
+}
+
+// DetectSeparators infer separators from sample keys. A separator is a punctuation appearing inside many keys,
+// and the prefixes before it are shared by many keys, so word joiners inside ids like '-' of uuid are excluded.
+// A separator always repeated like "::" is returned as a whole, result is sorted by score
+func DetectSeparators(keys []string) []string {
+	if len(keys) == 0 {
+		return nil
+	}
+	stats := make(map[byte]*separatorStat)
+	for _, key := range keys {
+		seen := make(map[byte]bool)
+		for i := 0; i < len(key); i++ {
+			c := key[i]
+			if !isSeparatorCandidate(c) {
+				continue
+			}
+			stat, ok := stats[c]
+			if !ok {
+				stat = &separatorStat{runNum: make(map[int]int), prefixes: make(map[string]bool)}
+				stats[c] = stat
+			}
+			run := 1
+			for i+run < len(key) && key[i+run] == c {
+				run++
+			}
+			stat.num++
+			stat.runNum[run]++
+			if i == 0 || i+run == len(key) {
+				stat.edgeNum++
+			}
+			if !seen[c] {
+				seen[c] = true
+				stat.keyNum++
+				stat.prefixes[key[:i]] = true
+			}
+			i += run - 1
+		}
+	}
+
+	type candidate struct {
+		separator string
+		score     float64
+	}
+	var candidates []candidate
+	for c, stat := range stats {
+		coverage := float64(stat.keyNum) / float64(len(keys))
+		reuse := 1 - float64(len(stat.prefixes))/float64(stat.keyNum)
+		interior := 1 - float64(stat.edgeNum)/float64(stat.num)
+		score := coverage * reuse
+		if coverage < 0.1 || reuse < 0.5 || interior < 0.9 || score < 0.1 {
+			continue
+		}
+		// the most common run length decide single or repeated separator
+		run, runNum := 1, 0
+		for r, n := range stat.runNum {
+			if n > runNum || n == runNum && r < run {
+				run, runNum = r, n
+			}
+		}
+		candidates = append(candidates, candidate{separator: strings.Repeat(string(c), run), score: score})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].separator < candidates[j].separator
+	})
+	separators := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		separators = append(separators, c.separator)
+	}
+	return separators
+}
+
+func isSeparatorCandidate(c byte) bool {
+	return c > ' ' && c < 0x7f && !('0' <= c && c <= '9') && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z')
+}
+
+// SeparatorsAuto as Separators is the same as AutoSeparators
+const SeparatorsAuto = "auto"
+
+// DetectSeparators sample DetectSampleNum keys from the sources to analyze and infer separators
+func (a *Analyzer) DetectSeparators() []string {
+	sources, err := a.sources()
+	errorJudge("prepare sources", err)
+	return a.detectSeparators(sources)
+}
+
+// detectSeparators sample keys evenly from sources, which are lines of key file, backends or the instance
+func (a *Analyzer) detectSeparators(sources []*Analyzer) []string {
+	var keys []string
+	for _, source := range sources {
+		keys = append(keys, source.sampleKeys((DetectSampleNum+len(sources)-1)/len(sources))...)
+	}
+	separators := DetectSeparators(keys)
+	log.Printf("detect separators %q from %d keys\n", separators, len(keys))
+	return separators
+}
+
+// sampleKeys return at most num keys from key file, or by SCAN, or by RANDOMKEY if server reject SCAN
+func (a *Analyzer) sampleKeys(num int) []string {
+	var keys []string
+	if a.KeyFile != "" {
+		file := a.openKeyFile()
+		defer file.Close()
+		for len(keys) < num {
+			key, ok := file.next()
+			if !ok {
+				break
+			}
+			keys = append(keys, key)
+		}
+		return keys
+	}
+
+	conn := a.Dial()
+	defer conn.Close()
+	var cursor int
+	for {
+		results, err := redigo.Values(conn.Do("SCAN", cursor, "MATCH", a.Match, "COUNT", a.Count))
+		if _, ok := err.(redigo.Error); ok && cursor == 0 {
+			log.Printf("SCAN rejected:%v, sample keys by RANDOMKEY to detect separators\n", err)
+			return a.randomKeys(conn, num)
+		}
+		errorJudge("scan redis", err)
+		cursor, _ = redigo.Int(results[0], nil)
+		batch, _ := redigo.Strings(results[1], nil)
+		keys = append(keys, batch...)
+		if cursor == 0 || len(keys) >= num {
+			return keys
+		}
+	}
+}
+
+// randomKeys return at most num distinct keys matching Match by RANDOMKEY
+func (a *Analyzer) randomKeys(conn redigo.Conn, num int) []string {
+	var match []string
+	if a.Match != "" && a.Match != "*" {
+		match = []string{a.Match}
+	}
+	matchFilter, err := newKeyFilter(match, nil)
+	errorJudge("parse match pattern", err)
+	for i := 0; i < num; i++ {
+		err := conn.Send("RANDOMKEY")
+		errorJudge("redis conn Send RANDOMKEY cmd", err)
+	}
+	err = conn.Flush()
+	errorJudge("redis conn Flush", err)
+	var keys []string
+	seen := make(map[string]bool)
+	for i := 0; i < num; i++ {
+		key, err := redigo.String(conn.Receive())
+		if err == redigo.ErrNil { // empty db
+			continue
+		}
+		errorJudge("sample keys by RANDOMKEY, set backends, discover or key file if behind proxy", err)
+		if !seen[key] && matchFilter.Match(key) {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// detectSeparatorsIfNeed set Separators or Rules by separators detected from sources if both are empty,
+// rules are built directly since separators like ';' can not be parsed from Rules, which is only for display
+func (a *Analyzer) detectSeparatorsIfNeed(sources []*Analyzer) {
+	if a.Separators == SeparatorsAuto {
+		a.Separators = ""
+		a.AutoSeparators = true
+	}
+	if !a.AutoSeparators || a.Separators != "" || a.Rules != "" {
+		return
+	}
+	separators := a.detectSeparators(sources)
+	for _, separator := range separators {
+		if len(separator) > 1 {
+			a.detectedRules = []tree.Rule{{MaxDepth: -1, Separators: separators}}
+			a.Rules = "0-=" + strings.Join(separators, " ")
+			return
+		}
+	}
+	a.Separators = strings.Join(separators, "")
+}
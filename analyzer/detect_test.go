@@ -0,0 +1,84 @@
+package analyzer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+func TestDetectSeparators(t *testing.T) {
+	var keys []string
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("user:%d:profile", i))
+		keys = append(keys, fmt.Sprintf("session:%08x-%04x-%04x", i*7919, i*31, i))
+		keys = append(keys, fmt.Sprintf("app::cache::%d", i))
+	}
+	if got, want := DetectSeparators(keys), []string{":"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	keys = keys[:0]
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("app::user::%d", i))
+		keys = append(keys, fmt.Sprintf("app::order/%d", i))
+	}
+	if got, want := DetectSeparators(keys), []string{"::", "/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestDetectSeparatorsFromKeyFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(file, "app/user/%d\napp/order/%d\n\n", i, i)
+	}
+	file.Close()
+
+	a := &Analyzer{KeyFile: file.Name(), Match: "app/user/*", Separators: SeparatorsAuto}
+	sources, err := a.sources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.detectSeparatorsIfNeed(sources)
+	if a.Separators != "/" || a.Rules != "" || !a.AutoSeparators {
+		t.Errorf("Expected separators / detected from key file, got %q, rules %q", a.Separators, a.Rules)
+	}
+}
+
+func TestDetectSemicolonSeparators(t *testing.T) {
+	file, err := ioutil.TempFile("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(file, "app;;user;;%d\napp;;order;;%d\n", i, i)
+	}
+	file.Close()
+
+	// ';' separates rules of Rules, so detected rules are not parsed from it
+	a := &Analyzer{KeyFile: file.Name(), AutoSeparators: true}
+	sources, err := a.sources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.detectSeparatorsIfNeed(sources)
+	want := []tree.Rule{{MaxDepth: -1, Separators: []string{";;"}}}
+	if rules := a.SeparatorRules(); !reflect.DeepEqual(rules, want) {
+		t.Fatalf("Expected rules %v, got %v", want, rules)
+	}
+	k := NewKeyTypeTree(a.SeparatorRules())
+	k.AddKey(&KeyInfo{Key: "app;;user;;1", KeyT: KeyTypeString, Size: 1})
+	k.AddKey(&KeyInfo{Key: "app;;order;;1", KeyT: KeyTypeString, Size: 1})
+	if children := k.Snapshot().Expand("app;;", KeyTypeString); len(children) != 2 || children["user;;"] == nil || children["order;;"] == nil {
+		t.Errorf("Expected user;; and order;; under app;;, got %v", children)
+	}
+}
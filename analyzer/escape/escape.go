@@ -0,0 +1,83 @@
+package escape
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Keys are kept in strings, which hold any bytes, they are only escaped by Mode when printed or encoded as json
+
+type Mode string
+
+const (
+	Auto   Mode = "auto"   // keep printable utf-8 as it is, otherwise quote it like Quote
+	Raw    Mode = "raw"    // write bytes as they are
+	Quote  Mode = "quote"  // go-style double quoted string
+	Hex    Mode = "hex"    // lower case hex of bytes
+	Base64 Mode = "base64" // standard base64 with padding
+)
+
+// Parse return mode by name, Auto if name is empty
+func Parse(name string) (Mode, error) {
+	switch m := Mode(name); m {
+	case "":
+		return Auto, nil
+	case Auto, Raw, Quote, Hex, Base64:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown escape mode %q, must be one of auto, raw, quote, hex, base64", name)
+}
+
+func (m Mode) Escape(s string) string {
+	switch m {
+	case Raw:
+		return s
+	case Quote:
+		return strconv.Quote(s)
+	case Hex:
+		return hex.EncodeToString([]byte(s))
+	case Base64:
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	if printable(s) {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// Unescape return the original string of Escape
+func (m Mode) Unescape(s string) (string, error) {
+	switch m {
+	case Raw:
+		return s, nil
+	case Quote:
+		return strconv.Unquote(s)
+	case Hex:
+		b, err := hex.DecodeString(s)
+		return string(b), err
+	case Base64:
+		b, err := base64.StdEncoding.DecodeString(s)
+		return string(b), err
+	}
+	if printable(s) {
+		return s, nil
+	}
+	return strconv.Unquote(s)
+}
+
+// printable return true if s is valid utf-8 without control characters, and doesn't look like quoted by Auto
+func printable(s string) bool {
+	if !utf8.ValidString(s) || len(s) > 0 && s[0] == '"' {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package escape
+
+import (
+	"testing"
+)
+
+func TestEscape(t *testing.T) {
+	cases := []struct {
+		mode Mode
+		key  string
+		want string
+	}{
+		{Auto, "user:1", "user:1"},
+		{Auto, "用户:1", "用户:1"},
+		{Auto, "id:\x08\x96\x01", `"id:\b\x96\x01"`},
+		{Auto, `"quoted"`, `"\"quoted\""`},
+		{Raw, "id:\x96", "id:\x96"},
+		{Quote, "user:1", `"user:1"`},
+		{Hex, "id:\x96", "69643a96"},
+		{Base64, "id:\x96", "aWQ6lg=="},
+	}
+	for _, c := range cases {
+		if got := c.mode.Escape(c.key); got != c.want {
+			t.Errorf("%s escape %q expected %q, got %q", c.mode, c.key, c.want, got)
+		}
+		if got, err := c.mode.Unescape(c.want); err != nil || got != c.key {
+			t.Errorf("%s unescape %q expected %q, got %q %v", c.mode, c.want, c.key, got, err)
+		}
+	}
+	if _, err := Parse("url"); err == nil {
+		t.Errorf("Expected error of unknown mode")
+	}
+	if m, err := Parse(""); err != nil || m != Auto {
+		t.Errorf("Expected auto mode, got %q %v", m, err)
+	}
+}
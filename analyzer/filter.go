@@ -0,0 +1,77 @@
+package analyzer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/iccolo/rma/analyzer/glob"
+)
+
+// RegexpPrefix mark a filter pattern as regular expression, otherwise it is a redis style glob pattern
+const RegexpPrefix = "re:"
+
+// keyFilter apply include and exclude patterns on client side after SCAN
+type keyFilter struct {
+	include []matcher
+	exclude []matcher
+}
+
+// matcher match key by a glob or a regular expression
+type matcher func(key string) bool
+
+func newKeyFilter(include, exclude []string) (*keyFilter, error) {
+	f := &keyFilter{}
+	var err error
+	if f.include, err = compilePatterns(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compilePatterns(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Match return true if key match any include pattern (or no include pattern) and match none of exclude patterns
+func (f *keyFilter) Match(key string) bool {
+	if len(f.include) > 0 && !matchAny(f.include, key) {
+		return false
+	}
+	return !matchAny(f.exclude, key)
+}
+
+func (f *keyFilter) empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0
+}
+
+func matchAny(matchers []matcher, key string) bool {
+	for _, match := range matchers {
+		if match(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func compilePatterns(patterns []string) ([]matcher, error) {
+	matchers := make([]matcher, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		if !strings.HasPrefix(pattern, RegexpPrefix) {
+			if err := glob.Check(pattern); err != nil {
+				return nil, err
+			}
+			pattern := pattern
+			matchers = append(matchers, func(key string) bool { return glob.Match(pattern, key) })
+			continue
+		}
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, RegexpPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("compile pattern %q: %v", pattern, err)
+		}
+		matchers = append(matchers, re.MatchString)
+	}
+	return matchers, nil
+}
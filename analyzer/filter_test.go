@@ -0,0 +1,40 @@
+package analyzer
+
+import (
+	"testing"
+)
+
+func TestKeyFilter(t *testing.T) {
+	f, err := newKeyFilter(nil, []string{"cache:*", "lock:?", "re:^tmp_[0-9]+$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{
+		"cache:user:1": false,
+		"lock:a":       false,
+		"lock:ab":      true,
+		"tmp_42":       false,
+		"tmp_x":        true,
+		"user:1":       true,
+	} {
+		if got := f.Match(key); got != want {
+			t.Errorf("Match(%q) expected %v, got %v", key, want, got)
+		}
+	}
+
+	f, err = newKeyFilter([]string{"user:[0-9]*", `a\*b`}, []string{"user:9*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{
+		"user:1":  true,
+		"user:91": false,
+		"user:x":  false,
+		"a*b":     true,
+		"axb":     false,
+	} {
+		if got := f.Match(key); got != want {
+			t.Errorf("Match(%q) expected %v, got %v", key, want, got)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package glob
+
+import (
+	"fmt"
+)
+
+// Patterns are matched like SCAN MATCH of redis: '*' match any bytes, '?' any byte, '[...]' a byte in the class,
+// '[^...]' a byte not in it, '\' escape the next byte in or out of classes. Ranges like [z-a] are the same as [a-z]
+
+// Check return error if pattern has unclosed class or trailing escape, which redis would take literally
+func Check(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if i++; i == len(pattern) {
+				return fmt.Errorf("pattern %q ends with escape", pattern)
+			}
+		case '[':
+			_, n, ok := matchClass(pattern[i:], 0)
+			if !ok {
+				return fmt.Errorf("pattern %q has unclosed '['", pattern)
+			}
+			i += n - 1
+		}
+	}
+	return nil
+}
+
+// Match return true if pattern match s
+func Match(pattern, s string) bool {
+	return match(pattern, s, false)
+}
+
+// MatchPrefix return true if s is a prefix of any string matched by pattern
+func MatchPrefix(pattern, s string) bool {
+	return match(pattern, s, true)
+}
+
+func match(pattern, s string, partial bool) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if match(pattern, s[i:], partial) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return partial
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return partial
+			}
+			matched, n, _ := matchClass(pattern, s[0])
+			if !matched {
+				return false
+			}
+			pattern, s = pattern[n:], s[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 {
+				return partial
+			}
+			if pattern[0] != s[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass match c with the class at the beginning of pattern like [a-z] or [^0-9],
+// return the length of class and false if it is not closed, which ends at the end of pattern like redis
+func matchClass(pattern string, c byte) (bool, int, bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for ; i < len(pattern); i++ {
+		if pattern[i] == ']' {
+			return matched != negate, i + 1, true
+		}
+		lo, hi := pattern[i], pattern[i]
+		switch {
+		case lo == '\\' && i+1 < len(pattern): // escaped byte is not a range
+			i++
+			lo, hi = pattern[i], pattern[i]
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			hi = pattern[i+2]
+			i += 2
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+	return matched != negate, len(pattern), false
+}
@@ -0,0 +1,62 @@
+package glob
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"user:*", "user:1:name", true},
+		{"user:?", "user:12", false},
+		{"*:name", "user:1:name", true},
+		{"[a-c]x", "bx", true},
+		{"[c-a]x", "bx", true}, // reversed range
+		{"[^a]x", "ax", false},
+		{"[^a]x", "bx", true},
+		{"[!a]x", "!x", true}, // '!' is not negation like redis
+		{"[!a]x", "bx", false},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{`[\]]`, "]", true},
+		{`[\-a]`, "-", true},
+		{"[]a", "a", false}, // empty class matches nothing like redis
+		{"", "", true},
+		{"", "a", false},
+	}
+	for _, c := range cases {
+		if got := Match(c.pattern, c.s); got != c.want {
+			t.Errorf("Match(%q, %q) expected %v, got %v", c.pattern, c.s, c.want, got)
+		}
+	}
+}
+
+func TestMatchPrefix(t *testing.T) {
+	for s, want := range map[string]bool{
+		"":        true,
+		"user:":   true,
+		"user:1":  true,
+		"order:":  false,
+		"user:1:": true,
+	} {
+		if got := MatchPrefix("user:[0-9]:name", s); got != want {
+			t.Errorf("MatchPrefix(%q) expected %v, got %v", s, want, got)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for pattern, ok := range map[string]bool{
+		"user:[0-9]*": true,
+		`a\[`:         true,
+		"user:[1":     false,
+		`user\`:       false,
+	} {
+		if err := Check(pattern); (err == nil) != ok {
+			t.Errorf("Check(%q) expected ok %v, got %v", pattern, ok, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package analyzer
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Cursor is where a source stopped when the analysis is interrupted
+type Cursor struct {
+	Source string `json:"source"`
+	Type   string `json:"type,omitempty"` // key type of SCAN TYPE, empty if all types are scanned
+	Cursor uint64 `json:"cursor"`         // SCAN cursor to resume from, lines read of key file, or keys sampled
+}
+
+func (c *Cursor) String() string {
+	if c.Type == "" {
+		return fmt.Sprintf("%s cursor %d", c.Source, c.Cursor)
+	}
+	return fmt.Sprintf("%s type %s cursor %d", c.Source, c.Type, c.Cursor)
+}
+
+// interruption stop sources of a running analysis, see Stop
+type interruption struct {
+	once    sync.Once
+	stop    chan struct{}
+	mu      sync.Mutex
+	cursors []*Cursor
+}
+
+// Stop stop reading keys, keys read are still analyzed, then the tree is finished as interrupted with
+// cursors of sources. Run returns after that
+func (a *Analyzer) Stop() {
+	i := a.counter().interruption()
+	i.once.Do(func() {
+		close(i.stop)
+	})
+}
+
+// interruptionMu guard creating interruption, Stop may be called by signal handlers before AsyncRun
+var interruptionMu sync.Mutex
+
+// interruption return the interruption of a, which is created on first use
+func (a *Analyzer) interruption() *interruption {
+	interruptionMu.Lock()
+	defer interruptionMu.Unlock()
+	if a.interrupt == nil {
+		a.interrupt = &interruption{stop: make(chan struct{})}
+	}
+	return a.interrupt
+}
+
+func (a *Analyzer) stopped() bool {
+	select {
+	case <-a.counter().interruption().stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopAt record where the source stopped
+func (a *Analyzer) stopAt(typeStr string, cursor uint64) {
+	i := a.counter().interruption()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cursors = append(i.cursors, &Cursor{Source: a.SourceName(), Type: typeStr, Cursor: cursor})
+}
+
+func (a *Analyzer) stoppedCursors() []*Cursor {
+	i := a.counter().interruption()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]*Cursor(nil), i.cursors...)
+}
@@ -0,0 +1,79 @@
+package analyzer
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+func TestInterrupt(t *testing.T) {
+	a := &Analyzer{Host: "127.0.0.1", Port: 6379}
+	wg := &sync.WaitGroup{}
+	a.startProgress(wg)
+	if a.stopped() {
+		t.Fatal("should not be stopped before Stop")
+	}
+	a.Stop()
+	a.Stop() // stop twice is allowed
+	if !a.stopped() {
+		t.Fatal("should be stopped after Stop")
+	}
+	a.stopAt("hash", 42)
+
+	infoChan := make(chan []*KeyInfo, 1)
+	infoChan <- []*KeyInfo{
+		{Key: "order:1:a", KeyT: KeyTypeHash, Size: 10},
+		{Key: "order:1:b", KeyT: KeyTypeHash, Size: 20},
+	}
+	close(infoChan)
+	k := NewKeyTypeTree(tree.ByteRules([]byte(":")))
+	wg.Add(1)
+	a.updateTree(infoChan, k, wg)
+	wg.Wait()
+
+	s := k.Snapshot()
+	want := []*Cursor{{Source: "127.0.0.1:6379", Type: "hash", Cursor: 42}}
+	if s.Complete || !reflect.DeepEqual(s.Cursors, want) {
+		t.Fatalf("expect incomplete snapshot with cursors %v, got complete:%v cursors:%v", want, s.Complete, s.Cursors)
+	}
+	// single child nodes are merged as a finished tree
+	if children := s.Expand("", KeyTypeHash); len(children) != 1 || children["order:1:"] == nil {
+		t.Fatalf("expect merged single child node, got %v", children)
+	}
+	if !a.Progress().Done {
+		t.Fatal("progress should be done")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := s.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSnapshot(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Complete || !reflect.DeepEqual(loaded.Cursors, want) {
+		t.Fatalf("cursors are not saved, got complete:%v cursors:%v", loaded.Complete, loaded.Cursors)
+	}
+	if got := want[0].String(); got != "127.0.0.1:6379 type hash cursor 42" {
+		t.Fatalf("unexpected cursor string %q", got)
+	}
+}
+
+func TestStopAfterSourcesFinished(t *testing.T) {
+	a := &Analyzer{}
+	wg := &sync.WaitGroup{}
+	a.startProgress(wg)
+	a.Stop()
+	infoChan := make(chan []*KeyInfo)
+	close(infoChan)
+	k := NewKeyTypeTree(tree.ByteRules([]byte(":")))
+	wg.Add(1)
+	a.updateTree(infoChan, k, wg)
+	if s := k.Snapshot(); !s.Complete || len(s.Cursors) != 0 {
+		t.Fatalf("all keys are read, expect a complete snapshot, got complete:%v cursors:%v", s.Complete, s.Cursors)
+	}
+}
@@ -4,49 +4,119 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 
 	"github.com/iccolo/rma/analyzer/tree"
 )
 
-func NewKeyTypeTree(separators []byte) *KeyTypeTree {
-	t := &KeyTypeTree{trees: [6]*tree.Tree{}}
+func NewKeyTypeTree(rules []tree.Rule) *KeyTypeTree {
+	t := &KeyTypeTree{trees: [6]*tree.Tree{}, bigKeyNum: DefaultBigKeyNum}
 	for i := 1; i <= 5; i++ {
-		t.trees[i] = tree.New(KeyTypeToTypeStr[i], separators)
+		t.trees[i] = tree.NewWithRules(KeyTypeToTypeStr[i], rules)
+		t.bigKeys[i] = &bigKeys{limit: DefaultBigKeyNum}
 	}
 	return t
 }
 
+// KeyTypeTree is written by the analyzer, and read by Snapshot, which is consistent with the final tree
 type KeyTypeTree struct {
-	trees [6]*tree.Tree
-	rw    sync.RWMutex
+	trees      [6]*tree.Tree
+	rw         sync.RWMutex
+	version    uint64    // increased by every write
+	complete   bool      // set by Finish
+	cursors    []*Cursor // where sources stopped, set by Interrupt
+	foldSize   int64
+	foldKeyNum int64
+	bigKeyNum  int
+	bigKeys    [6]*bigKeys
+	ttl        [6]*TTLStats // nil if TTL is not collected
+
+	snapshotMu sync.Mutex
+	snapshot   *Snapshot // latest snapshot
 }
 
 func (k *KeyTypeTree) AddKey(info *KeyInfo) {
 	k.rw.Lock()
 	defer k.rw.Unlock()
 	k.trees[info.KeyT].AddKey(info.Key, info.Size)
+	if b := k.bigKeys[info.KeyT]; b.accept(info.Size) { // most keys are not big, allocate only for those kept
+		b.add(&BigKey{Key: info.Key, Type: KeyTypeToTypeStr[info.KeyT], Size: info.Size, TTL: info.TTL})
+	}
+	if info.TTL != 0 {
+		if k.ttl[info.KeyT] == nil {
+			k.ttl[info.KeyT] = newTTLStats()
+		}
+		k.ttl[info.KeyT].add(info.TTL, info.Size)
+	}
+	k.version++
+}
+
+// SetBigKeyNum set the number of biggest keys kept for every type, DefaultBigKeyNum if 0, none if negative
+func (k *KeyTypeTree) SetBigKeyNum(num int) {
+	k.rw.Lock()
+	defer k.rw.Unlock()
+	if num == 0 {
+		num = DefaultBigKeyNum
+	}
+	k.bigKeyNum = num
+	for _, b := range k.bigKeys {
+		if b != nil {
+			b.limit = num
+			b.keys = sortBigKeys(b.keys, num)
+			for i := len(b.keys)/2 - 1; i >= 0; i-- {
+				b.down(i)
+			}
+		}
+	}
+	k.version++
 }
 
 func (k *KeyTypeTree) GetSize(keyPrefix string, keyT KeyType) int64 {
-	k.rw.RLock()
-	defer k.rw.RUnlock()
-	return k.trees[keyT].GetSize(keyPrefix)
+	return k.Snapshot().GetSize(keyPrefix, keyT)
 }
 
 func (k *KeyTypeTree) GetKeyTypeStr() []string {
-	var typeStrs []string
-	for keyType, typeStr := range KeyTypeToTypeStr {
-		if k.trees[keyType].GetKeyNum() > 0 {
-			typeStrs = append(typeStrs, typeStr)
+	return k.Snapshot().GetKeyTypeStr()
+}
+
+func (k *KeyTypeTree) Expand(keyPrefix string, keyT KeyType) map[string]*tree.Node {
+	return k.Snapshot().Expand(keyPrefix, keyT)
+}
+
+// SetSampling mark all trees as built from sampled keys
+func (k *KeyTypeTree) SetSampling(sampling *tree.Sampling) {
+	k.rw.Lock()
+	defer k.rw.Unlock()
+	for _, t := range k.trees {
+		if t == nil {
+			continue
 		}
+		t.SetSampling(sampling)
 	}
-	return typeStrs
+	k.version++
 }
 
-func (k *KeyTypeTree) Expand(keyPrefix string, keyT KeyType) map[string]*tree.Node {
-	k.rw.RLock()
-	defer k.rw.RUnlock()
-	return k.trees[keyT].Expand(keyPrefix)
+// AddSampling pool sampled keys of a source into sampling of trees
+func (k *KeyTypeTree) AddSampling(population, sampleNum int64, confidence float64) {
+	k.rw.Lock()
+	defer k.rw.Unlock()
+	if sampling := k.trees[KeyTypeString].GetSampling(); sampling != nil {
+		population += sampling.Population
+		sampleNum += sampling.SampleNum
+	}
+	sampling := tree.NewSampling(population, sampleNum, confidence)
+	for _, t := range k.trees {
+		if t == nil {
+			continue
+		}
+		t.SetSampling(sampling)
+	}
+	k.version++
+}
+
+// GetSampling return nil if trees are built by full scan
+func (k *KeyTypeTree) GetSampling() *tree.Sampling {
+	return k.Snapshot().GetSampling()
 }
 
 func (k *KeyTypeTree) MergeSingleChildNode() {
@@ -58,57 +128,161 @@ func (k *KeyTypeTree) MergeSingleChildNode() {
 		}
 		t.MergeSingleChildNode()
 	}
+	k.version++
 }
 
-func (k *KeyTypeTree) Print() {
-	fmt.Println("Summary:")
-	for i, t := range k.trees {
+// Interrupt mark trees as interrupted at cursors of sources, trees are not complete even if finished
+func (k *KeyTypeTree) Interrupt(cursors []*Cursor) {
+	k.rw.Lock()
+	defer k.rw.Unlock()
+	k.cursors = append(k.cursors, cursors...)
+	k.version++
+}
+
+// Finish merge single child nodes and fold small children, trees are complete
+func (k *KeyTypeTree) Finish() {
+	k.rw.Lock()
+	defer k.rw.Unlock()
+	finalize(k.trees, k.foldSize, k.foldKeyNum)
+	k.complete = true
+	k.version++
+}
+
+// finalize trees to look like a complete one
+func finalize(trees [6]*tree.Tree, foldSize, foldKeyNum int64) {
+	for _, t := range trees {
 		if t == nil {
 			continue
 		}
-		fmt.Printf("Type:%s KeyNum:%d TotalSize:%d\n", KeyTypeToTypeStr[i], t.GetKeyNum(), t.GetTotalSize())
+		t.MergeSingleChildNode()
+		t.Fold(foldSize, foldKeyNum)
 	}
-	fmt.Println("Detail:")
+}
+
+// SetMaxDepth stop descending past depth in all trees, 0 for no limit
+func (k *KeyTypeTree) SetMaxDepth(depth int) {
+	k.rw.Lock()
+	defer k.rw.Unlock()
 	for _, t := range k.trees {
 		if t == nil {
 			continue
 		}
-		t.Print()
-		fmt.Println()
+		t.SetMaxDepth(depth)
+	}
+}
+
+// SetSource name the source of keys added to all trees, e.g. the instance
+func (k *KeyTypeTree) SetSource(source string) {
+	k.rw.Lock()
+	defer k.rw.Unlock()
+	for _, t := range k.trees {
+		if t != nil {
+			t.SetSource(source)
+		}
+	}
+}
+
+// Merge add keys of o into k type by type, nodes keep what every source contributes
+func (k *KeyTypeTree) Merge(o *KeyTypeTree) error {
+	if k == o {
+		return fmt.Errorf("merge tree with itself")
+	}
+	// o is frozen before locking k, so that merging both ways at the same time don't deadlock
+	var others [6]*tree.Tree
+	var otherKeys [6][]*BigKey
+	var otherTTL [6]*TTLStats
+	o.rw.RLock()
+	for i, t := range o.trees {
+		if t != nil {
+			others[i] = t.Freeze()
+			otherKeys[i] = append([]*BigKey(nil), o.bigKeys[i].keys...)
+			otherTTL[i] = o.ttl[i].merge(nil)
+		}
+	}
+	o.rw.RUnlock()
+
+	k.rw.Lock()
+	defer k.rw.Unlock()
+	if err := mergeTrees(&k.trees, others); err != nil {
+		return err
+	}
+	for i, b := range k.bigKeys {
+		if b == nil {
+			continue
+		}
+		for _, key := range otherKeys[i] {
+			b.add(key)
+		}
+		k.ttl[i] = k.ttl[i].merge(otherTTL[i])
+	}
+	if k.complete {
+		finalize(k.trees, k.foldSize, k.foldKeyNum)
+	}
+	k.version++
+	return nil
+}
+
+func mergeTrees(trees *[6]*tree.Tree, others [6]*tree.Tree) error {
+	for i, t := range trees {
+		if t == nil {
+			continue
+		}
+		if err := t.Merge(others[i]); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// SetFold set thresholds folding small children into tree.OtherSegment by Finish and snapshots
+func (k *KeyTypeTree) SetFold(minSize, minKeyNum int64) {
+	k.rw.Lock()
+	defer k.rw.Unlock()
+	k.foldSize = minSize
+	k.foldKeyNum = minKeyNum
+	k.version++
+}
+
+func (k *KeyTypeTree) Print() {
+	k.Snapshot().Print()
 }
 
 type KeyInfo struct {
-	Key  string
-	KeyT KeyType
-	Size int64
+	Key      string
+	KeyT     KeyType
+	Encoding string // only for encoding aware size mode
+	Size     int64
+	TTL      int64 // ms, -1 for no expiry, 0 if not collected
 }
 
-func (a *Analyzer) analysisKey(keysChan chan []string, tree *KeyTypeTree, wg *sync.WaitGroup) {
-	defer wg.Done()
+// analysisKey get type and size of keys, return the channel of keys with size
+func (a *Analyzer) analysisKey(keysChan chan []*KeyInfo, wg *sync.WaitGroup) chan []*KeyInfo {
 	var (
 		withTypeChan = make(chan []*KeyInfo, 100)
 		withSizeChan = make(chan []*KeyInfo, 100)
 	)
-	wg.Add(3)
+	wg.Add(2)
 	go a.getKeyType(keysChan, withTypeChan, wg)
 	go a.getKeySize(withTypeChan, withSizeChan, wg)
-	go a.updateTree(withSizeChan, tree, wg)
+	return withSizeChan
 }
 
 func (a *Analyzer) updateTree(infoChan chan []*KeyInfo, tree *KeyTypeTree, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	var num int
+	p := a.counter().progress
 	for infos := range infoChan {
 		for _, info := range infos {
 			tree.AddKey(info)
-			num++
-			if num%1000 == 0 {
-				log.Printf("have analyze %v thousand keys\n", num/1000)
-			}
+			atomic.AddInt64(&p.analyzed, 1)
+			atomic.AddInt64(&p.bytes, info.Size)
 		}
 	}
-	tree.MergeSingleChildNode()
+	if cursors := a.stoppedCursors(); len(cursors) > 0 {
+		tree.Interrupt(cursors)
+		log.Printf("analyze interrupted at %v\n", cursors)
+	}
+	tree.Finish()
+	close(p.done)
 	log.Println("analyze finish")
 }
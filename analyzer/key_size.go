@@ -1,6 +1,10 @@
 package analyzer
 
 import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -8,47 +12,50 @@ import (
 	"github.com/iccolo/rma/analyzer/size"
 )
 
+// DefaultElementSample is the element num sampled from a collection when ElementSample is not set
+const DefaultElementSample = 5
+
+// size modes of keys
+const (
+	SizeModeMemoryUsage   = "memory-usage"   // MEMORY USAGE with MemorySamples
+	SizeModeEstimate      = "estimate"       // estimate by sampled elements
+	SizeModeExact         = "exact"          // estimate by all elements
+	SizeModeEncodingAware = "encoding-aware" // estimate by sampled elements and OBJECT ENCODING
+)
+
+// GetSizeMode return SizeMode, or decide by Cluster for compatibility if not set
+func (a *Analyzer) GetSizeMode() string {
+	if a.SizeMode != "" {
+		return a.SizeMode
+	}
+	if a.Cluster {
+		return SizeModeEstimate
+	}
+	return SizeModeMemoryUsage
+}
+
 func (a *Analyzer) getKeySize(inChan chan []*KeyInfo, outChan chan []*KeyInfo, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	conn := a.Dial()
 	defer conn.Close()
 
-	if !a.Cluster {
-		for infos := range inChan {
-			for _, info := range infos {
-				err := conn.Send("MEMORY USAGE", info.Key)
-				errorJudge("redis conn Send MEMORY USAGE cmd", err)
-			}
-			err := conn.Flush()
-			errorJudge("redis conn Flush", err)
-			for _, info := range infos {
-				result, err := redigo.Int64(conn.Receive())
-				errorJudge("redis conn Receive", err)
-				info.Size = result
-			}
-			outChan <- infos
-			time.Sleep(a.Pause * time.Millisecond)
-		}
-		close(outChan)
-		return
+	mode := a.GetSizeMode()
+	switch mode {
+	case SizeModeMemoryUsage, SizeModeEstimate, SizeModeExact, SizeModeEncodingAware:
+	default:
+		errorJudge("check size mode", fmt.Errorf("unknown size mode %q", mode))
 	}
-
+	sampler := a.newElementSampler(conn, mode)
 	for infos := range inChan {
-		for _, info := range infos {
-			if f, ok := sendFunctions[info.KeyT]; ok {
-				f(conn, info.Key)
+		if mode == SizeModeMemoryUsage {
+			if err := a.memoryUsage(conn, infos); err != nil {
+				log.Printf("MEMORY USAGE rejected:%v, fall back to %s size mode\n", err, SizeModeEstimate)
+				mode = SizeModeEstimate
 			}
 		}
-		err := conn.Flush()
-		errorJudge("redis conn Flush", err)
-		for _, info := range infos {
-			if f, ok := receiveFunctions[info.KeyT]; ok {
-				members, length := f(conn)
-				if ff, ok := sizeFunctions[info.KeyT]; ok {
-					info.Size = int64(ff(info.Key, members, length))
-				}
-			}
+		if mode != SizeModeMemoryUsage {
+			sampler.estimate(conn, infos)
 		}
 		outChan <- infos
 		time.Sleep(a.Pause * time.Millisecond)
@@ -57,20 +64,164 @@ func (a *Analyzer) getKeySize(inChan chan []*KeyInfo, outChan chan []*KeyInfo, w
 	return
 }
 
-var sendFunctions = map[KeyType]func(conn redigo.Conn, key string){
-	KeyTypeString: sendReadStringCmd,
-	KeyTypeList:   sendReadListCmd,
-	KeyTypeSet:    sendReadSetCmd,
-	KeyTypeHash:   sendReadHashCmd,
-	KeyTypeZset:   sendReadZsetCmd,
+// memoryUsage set size of keys by MEMORY USAGE, return error if server reject the command
+func (a *Analyzer) memoryUsage(conn redigo.Conn, infos []*KeyInfo) error {
+	args := redigo.Args{}
+	if a.MemorySamples > 0 {
+		args = args.Add("SAMPLES", a.MemorySamples)
+	} else if a.MemorySamples < 0 {
+		args = args.Add("SAMPLES", 0)
+	}
+	for _, info := range infos {
+		err := conn.Send("MEMORY", append(redigo.Args{"USAGE", info.Key}, args...)...)
+		errorJudge("redis conn Send MEMORY USAGE cmd", err)
+	}
+	err := conn.Flush()
+	errorJudge("redis conn Flush", err)
+	var rejected error
+	for _, info := range infos {
+		result, err := redigo.Int64(conn.Receive())
+		if _, ok := err.(redigo.Error); ok {
+			rejected = err
+			continue
+		}
+		if err == redigo.ErrNil { // key deleted meanwhile
+			continue
+		}
+		errorJudge("redis conn Receive", err)
+		info.Size = result
+	}
+	return rejected
 }
 
-var receiveFunctions = map[KeyType]func(conn redigo.Conn) ([][]byte, int){
-	KeyTypeString: receiveString,
-	KeyTypeList:   receiveList,
-	KeyTypeSet:    receiveSet,
-	KeyTypeHash:   receiveHash,
-	KeyTypeZset:   receiveZset,
+// elementSampler read elements of collections to estimate key size
+type elementSampler struct {
+	sample         int  // element num sampled from a collection
+	exactThreshold int  // read all elements of collections not longer than it
+	randCmd        bool // support HRANDFIELD and ZRANDMEMBER since redis 6.2
+	encodingAware  bool // estimate by OBJECT ENCODING
+	coefficients   *Coefficients
+	rand           *rand.Rand
+}
+
+func (a *Analyzer) newElementSampler(conn redigo.Conn, mode string) *elementSampler {
+	s := &elementSampler{
+		sample:         int(a.ElementSample),
+		exactThreshold: int(a.ExactThreshold),
+		randCmd:        versionAtLeast(conn, 6, 2),
+		encodingAware:  mode == SizeModeEncodingAware,
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if s.sample == 0 {
+		s.sample = DefaultElementSample
+	}
+	if s.exactThreshold < s.sample { // reading all is cheaper than sampling
+		s.exactThreshold = s.sample
+	}
+	if mode == SizeModeExact { // collections longer than exactChunkSize are read in chunks
+		s.exactThreshold = math.MaxInt32
+	}
+	if a.Coefficients != "" {
+		coefficients, err := loadCoefficientsOfMode(a.Coefficients, mode)
+		errorJudge("load coefficients", err)
+		s.coefficients = coefficients
+	}
+	return s
+}
+
+// estimate set size of keys in two round trips, one for length and one for sampled elements
+func (s *elementSampler) estimate(conn redigo.Conn, infos []*KeyInfo) {
+	encodingAware := s.encodingAware
+	for _, info := range infos {
+		err := conn.Send(lengthCommands[info.KeyT], info.Key)
+		errorJudge("redis conn Send "+lengthCommands[info.KeyT]+" cmd", err)
+		if encodingAware {
+			err = conn.Send("OBJECT", "ENCODING", info.Key)
+			errorJudge("redis conn Send OBJECT ENCODING cmd", err)
+		}
+	}
+	err := conn.Flush()
+	errorJudge("redis conn Flush", err)
+	lengths := make([]int, len(infos))
+	for i := range infos {
+		lengths[i], err = redigo.Int(conn.Receive())
+		errorJudge("redis conn Receive", err)
+		if !encodingAware {
+			continue
+		}
+		infos[i].Encoding, err = redigo.String(conn.Receive())
+		if _, ok := err.(redigo.Error); ok {
+			if s.encodingAware {
+				log.Printf("OBJECT ENCODING rejected:%v, fall back to %s size mode\n", err, SizeModeEstimate)
+				s.encodingAware = false
+			}
+			continue
+		}
+		if err == redigo.ErrNil { // key deleted meanwhile
+			continue
+		}
+		errorJudge("redis conn Receive", err)
+	}
+
+	receivers := make([]func(conn redigo.Conn) [][]byte, len(infos))
+	chunked := make([]bool, len(infos))
+	var pending bool
+	for i, info := range infos {
+		f, ok := sendFunctions[info.KeyT]
+		switch {
+		case !ok || lengths[i] <= 0:
+		case lengths[i] > exactChunkSize && lengths[i] <= s.exactThreshold: // read in chunks after the pipeline
+			chunked[i] = true
+		default:
+			receivers[i] = f(s, conn, info.Key, lengths[i])
+			pending = true
+		}
+	}
+	if pending {
+		err = conn.Flush()
+		errorJudge("redis conn Flush", err)
+	}
+	members := make([][][]byte, len(infos))
+	for i, receive := range receivers {
+		if receive != nil {
+			members[i] = receive(conn)
+		}
+	}
+	for i, info := range infos {
+		if chunked[i] {
+			members[i] = readAll(conn, info.KeyT, info.Key)
+		}
+	}
+
+	for i, info := range infos {
+		length := lengths[i]
+		members := members[i]
+		if (receivers[i] != nil || chunked[i]) && (length <= s.exactThreshold || len(members) == 0) {
+			length = -1 // full sample, or collection shrink meanwhile
+		}
+		if s.encodingAware && info.Encoding != "" {
+			info.Size = int64(encodedSizeFunctions[info.KeyT](info.Encoding, info.Key, members, length))
+		} else {
+			info.Size = int64(sizeFunctions[info.KeyT](info.Key, members, length))
+		}
+		info.Size = s.coefficients.Apply(info, info.Size)
+	}
+}
+
+var lengthCommands = map[KeyType]string{
+	KeyTypeString: "STRLEN",
+	KeyTypeList:   "LLEN",
+	KeyTypeSet:    "SCARD",
+	KeyTypeHash:   "HLEN",
+	KeyTypeZset:   "ZCARD",
+}
+
+// sendFunctions send commands reading sampled elements, return the function to receive them
+var sendFunctions = map[KeyType]func(s *elementSampler, conn redigo.Conn, key string, length int) func(conn redigo.Conn) [][]byte{
+	KeyTypeList: sendReadListCmd,
+	KeyTypeSet:  sendReadSetCmd,
+	KeyTypeHash: sendReadHashCmd,
+	KeyTypeZset: sendReadZsetCmd,
 }
 
 var sizeFunctions = map[KeyType]func(string, [][]byte, int) int{
@@ -81,77 +232,168 @@ var sizeFunctions = map[KeyType]func(string, [][]byte, int) int{
 	KeyTypeZset:   size.Zset,
 }
 
-const sample = 5
-
-func sendReadStringCmd(conn redigo.Conn, key string) {
-	err := conn.Send("STRLEN", key)
-	errorJudge("redis conn Send STRLEN cmd", err)
+var encodedSizeFunctions = map[KeyType]func(string, string, [][]byte, int) int{
+	KeyTypeString: size.StringEncoded,
+	KeyTypeList:   size.ListEncoded,
+	KeyTypeSet:    size.SetEncoded,
+	KeyTypeHash:   size.HashEncoded,
+	KeyTypeZset:   size.ZsetEncoded,
 }
 
-func sendReadListCmd(conn redigo.Conn, key string) {
-	err := conn.Send("LLEN", key)
-	errorJudge("redis conn Send LLEN cmd", err)
-	err = conn.Send("LRANGE", key, 0, sample-1)
-	errorJudge("redis conn Send STRLEN cmd", err)
+func sendReadListCmd(s *elementSampler, conn redigo.Conn, key string, length int) func(conn redigo.Conn) [][]byte {
+	if length <= s.exactThreshold {
+		err := conn.Send("LRANGE", key, 0, -1)
+		errorJudge("redis conn Send LRANGE cmd", err)
+		return receiveMembers
+	}
+	// LINDEX at distinct random offsets, so that both old and new elements are sampled
+	offsets := s.randomOffsets(length)
+	for offset := range offsets {
+		err := conn.Send("LINDEX", key, offset)
+		errorJudge("redis conn Send LINDEX cmd", err)
+	}
+	return func(conn redigo.Conn) [][]byte {
+		members := make([][]byte, 0, len(offsets))
+		for range offsets {
+			member, err := redigo.Bytes(conn.Receive())
+			if err == redigo.ErrNil { // list shrink meanwhile
+				continue
+			}
+			errorJudge("redis Receive", err)
+			members = append(members, member)
+		}
+		return members
+	}
 }
 
-func sendReadSetCmd(conn redigo.Conn, key string) {
-	err := conn.Send("SCARD", key)
-	errorJudge("redis conn Send SCARD cmd", err)
-	err = conn.Send("SRANDMEMBER", key, sample)
+func sendReadSetCmd(s *elementSampler, conn redigo.Conn, key string, length int) func(conn redigo.Conn) [][]byte {
+	if length <= s.exactThreshold {
+		err := conn.Send("SMEMBERS", key)
+		errorJudge("redis conn Send SMEMBERS cmd", err)
+		return receiveMembers
+	}
+	err := conn.Send("SRANDMEMBER", key, s.sample)
 	errorJudge("redis conn Send SRANDMEMBER cmd", err)
+	return receiveMembers
 }
 
-func sendReadHashCmd(conn redigo.Conn, key string) {
-	err := conn.Send("HLEN", key)
-	errorJudge("redis conn Send HLEN cmd", err)
-	err = conn.Send("HSCAN", key, 0, "COUNT", sample)
-	errorJudge("redis conn Send SSCAN cmd", err)
+func sendReadHashCmd(s *elementSampler, conn redigo.Conn, key string, length int) func(conn redigo.Conn) [][]byte {
+	if length <= s.exactThreshold {
+		err := conn.Send("HGETALL", key)
+		errorJudge("redis conn Send HGETALL cmd", err)
+		return receiveMembers
+	}
+	if s.randCmd {
+		err := conn.Send("HRANDFIELD", key, s.sample, "WITHVALUES")
+		errorJudge("redis conn Send HRANDFIELD cmd", err)
+		return receiveMembers
+	}
+	// HSCAN can not start at a random position, it reads the first buckets of the hash table. Fields are put in
+	// buckets by hash instead of insertion order, so they are not biased by age, but COUNT is only a hint,
+	// more or less fields than the sample may be returned
+	err := conn.Send("HSCAN", key, 0, "COUNT", s.sample)
+	errorJudge("redis conn Send HSCAN cmd", err)
+	return receiveScan
 }
 
-func sendReadZsetCmd(conn redigo.Conn, key string) {
-	err := conn.Send("ZCARD", key)
-	errorJudge("redis conn Send ZCARD cmd", err)
-	err = conn.Send("ZRANGE", key, 0, sample-1)
-	errorJudge("redis conn Send ZRANGE cmd", err)
+func sendReadZsetCmd(s *elementSampler, conn redigo.Conn, key string, length int) func(conn redigo.Conn) [][]byte {
+	if length <= s.exactThreshold {
+		err := conn.Send("ZRANGE", key, 0, -1)
+		errorJudge("redis conn Send ZRANGE cmd", err)
+		return receiveMembers
+	}
+	if s.randCmd {
+		err := conn.Send("ZRANDMEMBER", key, s.sample)
+		errorJudge("redis conn Send ZRANDMEMBER cmd", err)
+		return receiveMembers
+	}
+	// ZRANGE at distinct random ranks like LINDEX, instead of the lowest scores only
+	offsets := s.randomOffsets(length)
+	for offset := range offsets {
+		err := conn.Send("ZRANGE", key, offset, offset)
+		errorJudge("redis conn Send ZRANGE cmd", err)
+	}
+	return func(conn redigo.Conn) [][]byte {
+		members := make([][]byte, 0, len(offsets))
+		for range offsets {
+			members = append(members, receiveMembers(conn)...) // empty if zset shrink meanwhile
+		}
+		return members
+	}
 }
 
-func receiveString(conn redigo.Conn) ([][]byte, int) {
-	length, err := redigo.Int(conn.Receive())
-	errorJudge("redis Receive", err)
-	return nil, length
+// randomOffsets return s.sample distinct random offsets less than length, which is longer than s.sample
+func (s *elementSampler) randomOffsets(length int) map[int]bool {
+	offsets := make(map[int]bool, s.sample)
+	for len(offsets) < s.sample {
+		offsets[s.rand.Intn(length)] = true
+	}
+	return offsets
 }
 
-func receiveList(conn redigo.Conn) ([][]byte, int) {
-	length, err := redigo.Int(conn.Receive())
-	errorJudge("redis Receive", err)
-	members, err := redigo.ByteSlices(conn.Receive())
-	errorJudge("redis Receive", err)
-	return members, length
+// exactChunkSize is the max elements of a reply when reading all elements of a collection, longer ones are read
+// by paged LRANGE, or SSCAN, HSCAN and ZSCAN instead of LRANGE 0 -1, SMEMBERS, HGETALL and ZRANGE 0 -1
+const exactChunkSize = 1000
+
+var scanCommands = map[KeyType]string{
+	KeyTypeSet:  "SSCAN",
+	KeyTypeHash: "HSCAN",
+	KeyTypeZset: "ZSCAN",
 }
 
-func receiveSet(conn redigo.Conn) ([][]byte, int) {
-	length, err := redigo.Int(conn.Receive())
-	errorJudge("redis Receive", err)
-	members, err := redigo.ByteSlices(conn.Receive())
-	errorJudge("redis Receive", err)
-	return members, length
+// readAll read all elements of a collection in chunks, replies are the same as reading them by a single command
+func readAll(conn redigo.Conn, keyT KeyType, key string) [][]byte {
+	var members [][]byte
+	if keyT == KeyTypeList {
+		for start := 0; ; start += exactChunkSize {
+			page, err := redigo.ByteSlices(conn.Do("LRANGE", key, start, start+exactChunkSize-1))
+			errorJudge("redis LRANGE", err)
+			members = append(members, page...)
+			if len(page) < exactChunkSize {
+				return members
+			}
+		}
+	}
+	cmd := scanCommands[keyT]
+	seen := make(map[string]bool) // SCAN may return an element more than once
+	cursor := "0"
+	for {
+		results, err := redigo.Values(conn.Do(cmd, key, cursor, "COUNT", exactChunkSize))
+		errorJudge("redis "+cmd, err)
+		cursor, err = redigo.String(results[0], nil)
+		errorJudge("redis "+cmd+" cursor", err)
+		elements, err := redigo.ByteSlices(results[1], nil)
+		errorJudge("redis "+cmd+" elements", err)
+		for i := 0; i < len(elements); i++ {
+			member := elements[i]
+			if keyT != KeyTypeSet { // field and value of hash, or member and score of zset
+				i++
+			}
+			if seen[string(member)] {
+				continue
+			}
+			seen[string(member)] = true
+			members = append(members, member)
+			if keyT == KeyTypeHash && i < len(elements) {
+				members = append(members, elements[i])
+			}
+		}
+		if cursor == "0" {
+			return members
+		}
+	}
 }
 
-func receiveHash(conn redigo.Conn) ([][]byte, int) {
-	length, err := redigo.Int(conn.Receive())
-	errorJudge("redis Receive", err)
-	results, err := redigo.Values(conn.Receive())
-	errorJudge("redis Receive", err)
-	memberValues, err := redigo.ByteSlices(results[1], nil)
+func receiveMembers(conn redigo.Conn) [][]byte {
+	members, err := redigo.ByteSlices(conn.Receive())
 	errorJudge("redis Receive", err)
-	return memberValues, length
+	return members
 }
 
-func receiveZset(conn redigo.Conn) ([][]byte, int) {
-	length, err := redigo.Int(conn.Receive())
+func receiveScan(conn redigo.Conn) [][]byte {
+	results, err := redigo.Values(conn.Receive())
 	errorJudge("redis Receive", err)
-	members, err := redigo.ByteSlices(conn.Receive())
+	members, err := redigo.ByteSlices(results[1], nil)
 	errorJudge("redis Receive", err)
-	return members, length
+	return members
 }
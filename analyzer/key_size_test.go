@@ -0,0 +1,73 @@
+package analyzer
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// pagedConn reply LRANGE of list and two pages of SCAN of elements, the first element is repeated in both pages
+type pagedConn struct {
+	redigo.Conn
+	list     []string
+	elements []string
+}
+
+func (c *pagedConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "LRANGE" {
+		start, stop := args[1].(int), args[2].(int)+1
+		if stop > len(c.list) {
+			stop = len(c.list)
+		}
+		var page []interface{}
+		for _, member := range c.list[start:stop] {
+			page = append(page, []byte(member))
+		}
+		return page, nil
+	}
+	half := len(c.elements) / 2
+	page, cursor := c.elements[:half], "7"
+	if args[1].(string) == "7" {
+		page, cursor = append(c.elements[:2:2], c.elements[half:]...), "0"
+	}
+	var elements []interface{}
+	for _, element := range page {
+		elements = append(elements, []byte(element))
+	}
+	return []interface{}{[]byte(cursor), elements}, nil
+}
+
+func strs(members [][]byte) []string {
+	var s []string
+	for _, member := range members {
+		s = append(s, string(member))
+	}
+	return s
+}
+
+func TestReadAll(t *testing.T) {
+	list := make([]string, exactChunkSize+1)
+	for i := range list {
+		list[i] = strconv.Itoa(i)
+	}
+	if got := strs(readAll(&pagedConn{list: list}, KeyTypeList, "k")); !reflect.DeepEqual(got, list) {
+		t.Errorf("Expected %d elements of list, got %d", len(list), len(got))
+	}
+
+	for _, c := range []struct {
+		keyT     KeyType
+		elements []string
+		want     []string
+	}{
+		{KeyTypeSet, []string{"a", "b", "c", "d"}, []string{"a", "b", "c", "d"}},
+		{KeyTypeHash, []string{"f1", "v1", "f2", "v2"}, []string{"f1", "v1", "f2", "v2"}},
+		{KeyTypeZset, []string{"m1", "1", "m2", "2"}, []string{"m1", "m2"}},
+	} {
+		got := strs(readAll(&pagedConn{elements: c.elements}, c.keyT, "k"))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected %q, got %q", KeyTypeToTypeStr[c.keyT], c.want, got)
+		}
+	}
+}
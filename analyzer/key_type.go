@@ -3,6 +3,7 @@ package analyzer
 import (
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	redigo "github.com/gomodule/redigo/redis"
 )
@@ -10,6 +11,7 @@ import (
 type KeyType = int
 
 const (
+	KeyTypeAll    KeyType = 0 // view of all types, not a type of keys
 	KeyTypeString KeyType = 1
 	KeyTypeList   KeyType = 2
 	KeyTypeSet    KeyType = 3
@@ -17,39 +19,51 @@ const (
 	KeyTypeZset   KeyType = 5
 )
 
-func (a *Analyzer) getKeyType(keysChan chan []string, infoChan chan []*KeyInfo, wg *sync.WaitGroup) {
+func (a *Analyzer) getKeyType(keysChan chan []*KeyInfo, infoChan chan []*KeyInfo, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	conn := a.Dial()
 	defer conn.Close()
 
-	// set analyze key type, all types by default
-	types := make(map[string]KeyType)
-	for _, t := range strings.Split(a.Types, ",") {
-		if kt, ok := KeyTypeStrToType[t]; ok {
-			types[t] = kt
-		}
-	}
-	if len(types) == 0 {
-		types = KeyTypeStrToType
-	}
-
+	types := a.keyTypes()
 	for keys := range keysChan {
+		// keys from SCAN TYPE already have key type
+		var pending int
 		for _, key := range keys {
-			err := conn.Send("TYPE", key)
-			errorJudge("redis conn Send TYPE cmd", err)
+			if key.KeyT == 0 {
+				err := conn.Send("TYPE", key.Key)
+				errorJudge("redis conn Send TYPE cmd", err)
+				pending++
+			}
+			if a.TTL {
+				err := conn.Send("PTTL", key.Key)
+				errorJudge("redis conn Send PTTL cmd", err)
+				pending++
+			}
+		}
+		if pending > 0 {
+			err := conn.Flush()
+			errorJudge("redis conn Flush", err)
 		}
-		err := conn.Flush()
-		errorJudge("redis conn Flush", err)
 		infos := make([]*KeyInfo, 0, len(keys))
 		for _, key := range keys {
-			result, err := redigo.String(conn.Receive())
-			errorJudge("redis conn Receive", err)
-			if keyT, ok := types[result]; ok {
-				infos = append(infos, &KeyInfo{
-					Key:  key,
-					KeyT: keyT,
-				})
+			if key.KeyT == 0 {
+				result, err := redigo.String(conn.Receive())
+				errorJudge("redis conn Receive", err)
+				key.KeyT = types[result]
+				if result == "none" { // expired or deleted after SCAN
+					atomic.AddInt64(&a.counter().progress.errors, 1)
+				} else if key.KeyT == 0 {
+					atomic.AddInt64(&a.counter().progress.dropped, 1)
+				}
+			}
+			if a.TTL {
+				ttl, err := redigo.Int64(conn.Receive())
+				errorJudge("redis conn Receive", err)
+				key.TTL = pttl(ttl)
+			}
+			if key.KeyT != 0 {
+				infos = append(infos, key)
 			}
 		}
 		infoChan <- infos
@@ -57,6 +71,34 @@ func (a *Analyzer) getKeyType(keysChan chan []string, infoChan chan []*KeyInfo,
 	close(infoChan)
 }
 
+// pttl convert reply of PTTL to KeyInfo.TTL
+func pttl(ttl int64) int64 {
+	switch {
+	case ttl == -1:
+		return -1
+	case ttl < 0: // key not exists
+		return 0
+	case ttl == 0: // expiring, not to be taken as not collected
+		return 1
+	default:
+		return ttl
+	}
+}
+
+// keyTypes return the key types to analyze, all types by default
+func (a *Analyzer) keyTypes() map[string]KeyType {
+	types := make(map[string]KeyType)
+	for _, t := range strings.Split(a.Types, ",") {
+		if kt, ok := KeyTypeStrToType[t]; ok {
+			types[t] = kt
+		}
+	}
+	if len(types) == 0 {
+		types = KeyTypeStrToType
+	}
+	return types
+}
+
 var KeyTypeStrToType = map[string]KeyType{
 	"string": KeyTypeString,
 	"list":   KeyTypeList,
@@ -72,3 +114,15 @@ var KeyTypeToTypeStr = map[KeyType]string{
 	KeyTypeHash:   "hash",
 	KeyTypeZset:   "zset",
 }
+
+// KeyTypeAllStr is the type string of KeyTypeAll
+const KeyTypeAllStr = "all"
+
+// ParseKeyType parse type string of a view, which is a key type or KeyTypeAllStr
+func ParseKeyType(typeStr string) (KeyType, bool) {
+	if typeStr == KeyTypeAllStr {
+		return KeyTypeAll, true
+	}
+	keyT, ok := KeyTypeStrToType[typeStr]
+	return keyT, ok
+}
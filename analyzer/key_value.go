@@ -0,0 +1,120 @@
+package analyzer
+
+import (
+	"fmt"
+	"strconv"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/iccolo/rma/analyzer/escape"
+)
+
+// KeyValue is a key with its type, TTL and at most limit elements of value, strings are escaped by EscapeMode
+type KeyValue struct {
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`
+	TTL   int64       `json:"ttl"`
+	Value interface{} `json:"value"` // string, []string, map[string]string or []ZSetItem
+}
+
+type ZSetItem struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// GetKeyValue read key from redis for inspection, key is not escaped
+func (a *Analyzer) GetKeyValue(key string, limit int) (*KeyValue, error) {
+	mode := a.EscapeMode()
+	conn := a.Dial()
+	defer conn.Close()
+
+	res := &KeyValue{
+		Key: mode.Escape(key),
+	}
+	// 查看 key 的类型
+	keyType, err := redigo.String(conn.Do("TYPE", key))
+	if err != nil {
+		return res, fmt.Errorf("failed to get key type: %s", err)
+	}
+	res.Type = keyType
+
+	// 获取 key 的 TTL
+	ttl, err := redigo.Int64(conn.Do("TTL", key))
+	if err != nil {
+		return res, fmt.Errorf("failed to get key ttl: %s", err)
+	}
+	res.TTL = ttl
+
+	// 根据 key 的类型获取对应的值
+	switch keyType {
+	case "string":
+		// 获取 string 类型的值
+		result, err := redigo.String(conn.Do("GET", key))
+		if err != nil {
+			return res, fmt.Errorf("failed to get string result: %s", err)
+		}
+		res.Value = mode.Escape(result)
+	case "list":
+		// 获取 list 类型的值
+		results, err := redigo.Strings(conn.Do("LRANGE", key, 0, limit-1))
+		if err != nil {
+			return res, fmt.Errorf("failed to get list results: %s", err)
+		}
+		res.Value = escapeAll(mode, results)
+	case "hash":
+		// 获取 hash 类型的值
+		results, err := redigo.Values(conn.Do("HSCAN", key, 0, "COUNT", limit))
+		if err != nil {
+			return res, fmt.Errorf("failed to get hash results: %s", err)
+		}
+		values, err := redigo.StringMap(results[1], err)
+		if err != nil {
+			return res, fmt.Errorf("failed to get hash results: %s", err)
+		}
+		escaped := make(map[string]string, len(values))
+		for field, value := range values {
+			escaped[mode.Escape(field)] = mode.Escape(value)
+		}
+		res.Value = escaped
+	case "set":
+		// 获取 set 类型的值
+		results, err := redigo.Values(conn.Do("SSCAN", key, 0, "COUNT", limit))
+		if err != nil {
+			return res, fmt.Errorf("failed to get set results: %s", err)
+		}
+		values, err := redigo.Strings(results[1], err)
+		if err != nil {
+			return res, fmt.Errorf("failed to get set results: %s", err)
+		}
+		res.Value = escapeAll(mode, values)
+	case "zset":
+		// 获取 zset 类型的值
+		results, err := redigo.Values(conn.Do("ZRANGE", key, 0, limit-1, "WITHSCORES"))
+		if err != nil {
+			return res, fmt.Errorf("failed to get zset results: %s", err)
+		}
+		values := make([]ZSetItem, 0, len(results)/2)
+		for i := 0; i < len(results); i += 2 {
+			score, err := strconv.ParseFloat(string(results[i+1].([]byte)), 64)
+			if err != nil {
+				return res, fmt.Errorf("failed to parse zset score: %s", err)
+			}
+			values = append(values, ZSetItem{
+				Member: mode.Escape(string(results[i].([]byte))),
+				Score:  score,
+			})
+		}
+		res.Value = values
+	default:
+		return res, fmt.Errorf("unsupported redis key type '%s'", keyType)
+	}
+
+	return res, nil
+}
+
+func escapeAll(mode escape.Mode, values []string) []string {
+	escaped := make([]string, 0, len(values))
+	for _, value := range values {
+		escaped = append(escaped, mode.Escape(value))
+	}
+	return escaped
+}
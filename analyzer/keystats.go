@@ -0,0 +1,147 @@
+package analyzer
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultBigKeyNum is the number of biggest keys kept for every type
+const DefaultBigKeyNum = 100
+
+// BigKey is one of the biggest keys of a type
+type BigKey struct {
+	Key  string `json:"key"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	TTL  int64  `json:"ttl"` // ms, -1 for no expiry, 0 if TTL is not collected
+}
+
+// bigKeys keep the biggest keys in a min heap of size
+type bigKeys struct {
+	limit int
+	keys  []*BigKey
+}
+
+// accept return true if a key of size would be kept
+func (b *bigKeys) accept(size int64) bool {
+	return b.limit > 0 && (len(b.keys) < b.limit || size > b.keys[0].Size)
+}
+
+func (b *bigKeys) add(key *BigKey) {
+	if !b.accept(key.Size) {
+		return
+	}
+	if len(b.keys) < b.limit {
+		b.keys = append(b.keys, key)
+		b.up(len(b.keys) - 1)
+		return
+	}
+	b.keys[0] = key
+	b.down(0)
+}
+
+func (b *bigKeys) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if b.keys[parent].Size <= b.keys[i].Size {
+			return
+		}
+		b.keys[parent], b.keys[i] = b.keys[i], b.keys[parent]
+		i = parent
+	}
+}
+
+func (b *bigKeys) down(i int) {
+	for {
+		smallest := i
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(b.keys) && b.keys[child].Size < b.keys[smallest].Size {
+				smallest = child
+			}
+		}
+		if smallest == i {
+			return
+		}
+		b.keys[smallest], b.keys[i] = b.keys[i], b.keys[smallest]
+		i = smallest
+	}
+}
+
+// sortBigKeys sort keys by size desc and keep at most limit keys
+func sortBigKeys(keys []*BigKey, limit int) []*BigKey {
+	sorted := append([]*BigKey(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Size > sorted[j].Size
+	})
+	if limit < 0 {
+		limit = 0
+	}
+	if len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// TTLStats count keys and their size by TTL
+type TTLStats struct {
+	Buckets []*TTLBucket `json:"buckets"` // no expiry, then by ascending TTL
+}
+
+type TTLBucket struct {
+	Name   string `json:"name"`
+	Max    int64  `json:"max"` // ms, keys with TTL less than it and not less than Max of the previous bucket, 0 for no limit
+	KeyNum int64  `json:"key_num"`
+	Size   int64  `json:"size"`
+}
+
+// ttlBounds are upper bounds of TTL buckets
+var ttlBounds = []struct {
+	name string
+	max  time.Duration
+}{
+	{"< 1h", time.Hour},
+	{"< 1d", 24 * time.Hour},
+	{"< 7d", 7 * 24 * time.Hour},
+	{"< 30d", 30 * 24 * time.Hour},
+	{">= 30d", 0},
+}
+
+func newTTLStats() *TTLStats {
+	s := &TTLStats{Buckets: []*TTLBucket{{Name: "no expiry", Max: -1}}}
+	for _, bound := range ttlBounds {
+		s.Buckets = append(s.Buckets, &TTLBucket{Name: bound.name, Max: bound.max.Milliseconds()})
+	}
+	return s
+}
+
+// add key by TTL in ms, -1 for no expiry
+func (s *TTLStats) add(ttl, size int64) {
+	bucket := s.Buckets[0]
+	if ttl >= 0 {
+		for _, bucket = range s.Buckets[1:] {
+			if bucket.Max == 0 || ttl < bucket.Max {
+				break
+			}
+		}
+	}
+	bucket.KeyNum++
+	bucket.Size += size
+}
+
+// merge return stats of both, nil if neither collect TTL
+func (s *TTLStats) merge(o *TTLStats) *TTLStats {
+	if s == nil && o == nil {
+		return nil
+	}
+	merged := newTTLStats()
+	for _, stats := range []*TTLStats{s, o} {
+		if stats == nil {
+			continue
+		}
+		for i, bucket := range stats.Buckets {
+			merged.Buckets[i].KeyNum += bucket.KeyNum
+			merged.Buckets[i].Size += bucket.Size
+		}
+	}
+	return merged
+}
@@ -0,0 +1,159 @@
+package analyzer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// Progress of an analysis, counted for all backends
+type Progress struct {
+	Scanned  int64   `json:"scanned"`  // keys read by SCAN, RANDOMKEY or from key file
+	Analyzed int64   `json:"analyzed"` // keys added to tree
+	Dropped  int64   `json:"dropped"`  // keys dropped by Include, Exclude or Types
+	Errors   int64   `json:"errors"`   // keys vanished before their type or size is read
+	Bytes    int64   `json:"bytes"`    // size of analyzed keys
+	Total    int64   `json:"total"`    // keys expected by DBSIZE, Limit and Sample, 0 if unknown
+	Elapsed  float64 `json:"elapsed"`  // seconds
+	Rate     float64 `json:"rate"`     // keys analyzed, dropped or failed per second
+	ETA      float64 `json:"eta"`      // seconds, -1 if unknown
+	Done     bool    `json:"done"`
+}
+
+// Processed return keys which have gone through the pipeline
+func (p *Progress) Processed() int64 {
+	return p.Analyzed + p.Dropped + p.Errors
+}
+
+// progress counts of analyzer, keep int64 first for 64-bit atomic alignment
+type progress struct {
+	scanned  int64
+	analyzed int64
+	dropped  int64 // by type, keys dropped by filter are counted by filteredKeyNum
+	errors   int64
+	bytes    int64
+	total    int64
+
+	start    time.Time
+	done     chan struct{} // closed when tree is finished
+	watchers []*progressWatcher
+}
+
+type progressWatcher struct {
+	interval time.Duration
+	fn       func(Progress)
+}
+
+// OnProgress call fn with progress every interval while running, and once more when done.
+// It should be called before Run, Run returns after the last call
+func (a *Analyzer) OnProgress(interval time.Duration, fn func(Progress)) {
+	p := a.counter().progressCounter()
+	p.watchers = append(p.watchers, &progressWatcher{interval: interval, fn: fn})
+}
+
+// ProgressChan return a channel receiving progress every interval while running, which is closed after the
+// progress of done. Progress is dropped if the channel is not received in time, except the last one
+func (a *Analyzer) ProgressChan(interval time.Duration) <-chan Progress {
+	ch := make(chan Progress, 1)
+	a.OnProgress(interval, func(p Progress) {
+		if !p.Done {
+			select {
+			case ch <- p:
+			default:
+			}
+			return
+		}
+		select { // replace the stale one by the last progress
+		case <-ch:
+		default:
+		}
+		ch <- p
+		close(ch)
+	})
+	return ch
+}
+
+// Progress return progress of the running or finished analysis
+func (a *Analyzer) Progress() Progress {
+	c := a.counter()
+	pc := c.progressCounter()
+	p := Progress{
+		Scanned:  atomic.LoadInt64(&pc.scanned),
+		Analyzed: atomic.LoadInt64(&pc.analyzed),
+		Dropped:  atomic.LoadInt64(&pc.dropped) + atomic.LoadInt64(&c.filteredKeyNum),
+		Errors:   atomic.LoadInt64(&pc.errors),
+		Bytes:    atomic.LoadInt64(&pc.bytes),
+		Total:    atomic.LoadInt64(&pc.total),
+		ETA:      -1,
+	}
+	if pc.done != nil {
+		select {
+		case <-pc.done:
+			p.Done = true
+		default:
+		}
+	}
+	if pc.start.IsZero() {
+		return p
+	}
+	p.Elapsed = time.Since(pc.start).Seconds()
+	if p.Elapsed > 0 {
+		p.Rate = float64(p.Processed()) / p.Elapsed
+	}
+	switch {
+	case p.Done:
+		p.ETA = 0
+	case p.Total > 0 && p.Rate > 0:
+		p.ETA = float64(p.Total-p.Processed()) / p.Rate
+		if p.ETA < 0 {
+			p.ETA = 0
+		}
+	}
+	return p
+}
+
+// progressCounter return counts of a, which is created by OnProgress or AsyncRun before running
+func (a *Analyzer) progressCounter() *progress {
+	if a.progress == nil {
+		a.progress = &progress{}
+	}
+	return a.progress
+}
+
+// startProgress start counting and watchers, watchers are waited by wg
+func (a *Analyzer) startProgress(wg *sync.WaitGroup) {
+	p := a.progressCounter()
+	p.start = time.Now()
+	p.done = make(chan struct{})
+	for _, w := range p.watchers {
+		wg.Add(1)
+		go func(w *progressWatcher) {
+			defer wg.Done()
+			ticker := time.NewTicker(w.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					w.fn(a.Progress())
+				case <-p.done:
+					w.fn(a.Progress())
+					return
+				}
+			}
+		}(w)
+	}
+}
+
+// addTotal add keys expected from source, which is DBSIZE limited by Limit
+func (a *Analyzer) addTotal(conn redigo.Conn) {
+	size, err := redigo.Int64(conn.Do("DBSIZE"))
+	if err != nil { // not supported by some proxies, total is unknown then
+		return
+	}
+	if a.Limit > 0 && uint64(size) > a.Limit {
+		size = int64(a.Limit)
+	}
+	atomic.AddInt64(&a.counter().progressCounter().total, size)
+}
@@ -0,0 +1,71 @@
+package analyzer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+func TestProgress(t *testing.T) {
+	a := &Analyzer{}
+	var calls []Progress
+	a.OnProgress(time.Hour, func(p Progress) {
+		calls = append(calls, p)
+	})
+	ch := a.ProgressChan(time.Hour)
+
+	wg := &sync.WaitGroup{}
+	a.startProgress(wg)
+	a.progress.total = 10
+	a.progress.scanned = 6
+	a.progress.dropped = 1
+	a.filteredKeyNum = 1
+	a.progress.errors = 1
+
+	p := a.Progress()
+	if p.Done || p.Dropped != 2 || p.Processed() != 3 || p.Total != 10 {
+		t.Fatalf("unexpected progress %+v", p)
+	}
+	if p.ETA <= 0 || p.Rate <= 0 {
+		t.Fatalf("ETA and rate should be known, progress %+v", p)
+	}
+
+	infoChan := make(chan []*KeyInfo, 1)
+	infoChan <- []*KeyInfo{
+		{Key: "a:1", KeyT: KeyTypeString, Size: 10},
+		{Key: "a:2", KeyT: KeyTypeHash, Size: 20},
+	}
+	close(infoChan)
+	wg.Add(1)
+	a.updateTree(infoChan, NewKeyTypeTree(tree.ByteRules([]byte(":"))), wg)
+	wg.Wait()
+
+	if len(calls) != 1 {
+		t.Fatalf("expect the last call only, got %d calls", len(calls))
+	}
+	last := calls[0]
+	if !last.Done || last.Analyzed != 2 || last.Bytes != 30 || last.ETA != 0 {
+		t.Fatalf("unexpected last progress %+v", last)
+	}
+	var received []Progress
+	for p := range ch {
+		received = append(received, p)
+	}
+	if len(received) != 1 || !received[0].Done {
+		t.Fatalf("channel should receive the last progress and be closed, got %+v", received)
+	}
+}
+
+func TestProgressUnknownTotal(t *testing.T) {
+	a := &Analyzer{}
+	if p := a.Progress(); p.ETA != -1 || p.Elapsed != 0 {
+		t.Fatalf("progress before running should be empty, got %+v", p)
+	}
+	a.startProgress(&sync.WaitGroup{})
+	a.progress.analyzed = 5
+	if p := a.Progress(); p.ETA != -1 {
+		t.Fatalf("ETA should be unknown without total, got %+v", p)
+	}
+}
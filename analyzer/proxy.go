@@ -0,0 +1,374 @@
+package analyzer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/iccolo/rma/analyzer/escape"
+)
+
+// proxies usually reject SCAN and MEMORY, so redis servers behind them are scanned directly by Backends,
+// which can be discovered from the proxy admin by Discover, or keys are read from KeyFile and analyzed through proxy
+
+// discovery schemes of Discover
+const (
+	DiscoverCodis     = "codis://"     // codis dashboard, e.g. codis://127.0.0.1:18080
+	DiscoverTwemproxy = "twemproxy://" // twemproxy stats, e.g. twemproxy://127.0.0.1:22222
+	DiscoverEnvoy     = "envoy://"     // envoy admin with cluster name, e.g. envoy://127.0.0.1:9901/redis_cluster
+)
+
+// sources return analyzers of backends, or the analyzer itself if no backend
+func (a *Analyzer) sources() ([]*Analyzer, error) {
+	if a.KeyFile != "" {
+		return []*Analyzer{a}, nil
+	}
+	backends := a.Backends
+	if a.Discover != "" {
+		discovered, err := discoverBackends(a.Discover)
+		if err != nil {
+			return nil, fmt.Errorf("discover backends from %s: %v", a.Discover, err)
+		}
+		log.Printf("discover %d backends from %s: %v\n", len(discovered), a.Discover, discovered)
+		backends = append(append([]string{}, backends...), discovered...)
+	}
+	if len(backends) == 0 {
+		return []*Analyzer{a}, nil
+	}
+
+	sources := make([]*Analyzer, 0, len(backends))
+	for _, backend := range backends {
+		host, portStr, err := net.SplitHostPort(backend)
+		if err != nil {
+			return nil, fmt.Errorf("parse backend %s: %v", backend, err)
+		}
+		port, err := strconv.ParseUint(portStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("parse backend %s: %v", backend, err)
+		}
+		source := *a
+		source.origin = a
+		source.Host = host
+		source.Port = uint(port)
+		if a.BackendPassword != "" {
+			source.Password = a.BackendPassword
+		}
+		source.Backends = nil
+		source.Discover = ""
+		sources = append(sources, &source)
+	}
+	// Limit and Sample are of all backends, split in proportion to DBSIZE so that the pooled keys are self weighting
+	sizes, err := dbSizes(sources)
+	if err != nil {
+		return nil, err
+	}
+	for i, limit := range split(a.Limit, sizes) {
+		sources[i].Limit = limit
+	}
+	if a.Sample > 0 {
+		for i, sample := range split(a.Sample, sizes) {
+			sources[i].Sample = sample
+		}
+	}
+	return sources, nil
+}
+
+// dbSizes return DBSIZE of sources, error names the backend failing
+func dbSizes(sources []*Analyzer) ([]int64, error) {
+	sizes := make([]int64, len(sources))
+	for i, source := range sources {
+		conn, err := source.dial()
+		if err != nil {
+			return nil, fmt.Errorf("dial backend %s: %v", source.SourceName(), err)
+		}
+		sizes[i], err = redigo.Int64(conn.Do("DBSIZE"))
+		conn.Close()
+		if err != nil {
+			return nil, fmt.Errorf("DBSIZE of backend %s: %v", source.SourceName(), err)
+		}
+	}
+	return sizes, nil
+}
+
+// split num in proportion to sizes by the largest remainder, so that shares add up to num.
+// num is split evenly if all sizes are 0
+func split(num uint64, sizes []int64) []uint64 {
+	shares := make([]uint64, len(sizes))
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	if total == 0 {
+		for i := range shares {
+			shares[i] = num / uint64(len(shares))
+			if uint64(i) < num%uint64(len(shares)) {
+				shares[i]++
+			}
+		}
+		return shares
+	}
+	remainders := make([]float64, len(sizes))
+	order := make([]int, len(sizes))
+	var assigned uint64
+	for i, size := range sizes {
+		exact := float64(num) * float64(size) / float64(total)
+		shares[i] = uint64(exact)
+		remainders[i] = exact - float64(shares[i])
+		order[i] = i
+		assigned += shares[i]
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+	for _, i := range order {
+		if assigned >= num {
+			break
+		}
+		shares[i]++
+		assigned++
+	}
+	return shares
+}
+
+// mergeKeyInfo fan in channels of all sources, the merged channel is closed after all of them are closed
+func mergeKeyInfo(chans []chan []*KeyInfo) chan []*KeyInfo {
+	if len(chans) == 1 {
+		return chans[0]
+	}
+	merged := make(chan []*KeyInfo, 100)
+	wg := &sync.WaitGroup{}
+	wg.Add(len(chans))
+	for _, c := range chans {
+		go func(c chan []*KeyInfo) {
+			defer wg.Done()
+			for infos := range c {
+				merged <- infos
+			}
+		}(c)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+	return merged
+}
+
+// readKeyFile read keys from KeyFile, one key per line, instead of SCAN
+func (a *Analyzer) readKeyFile(keysChan chan []*KeyInfo, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	file := a.openKeyFile()
+	defer file.Close()
+
+	filter, err := newKeyFilter(a.Include, a.Exclude)
+	errorJudge("parse key filter", err)
+
+	batch := int(a.Count)
+	if batch == 0 {
+		batch = 1000
+	}
+	var num uint64
+	infos := make([]*KeyInfo, 0, batch)
+	for num < a.Limit {
+		key, ok := file.next()
+		if !ok {
+			break
+		}
+		num++
+		atomic.AddInt64(&a.counter().progress.scanned, 1)
+		if !filter.empty() && !filter.Match(key) {
+			atomic.AddInt64(&a.counter().filteredKeyNum, 1)
+			continue
+		}
+		infos = append(infos, &KeyInfo{Key: key})
+		if len(infos) == batch {
+			keysChan <- infos
+			infos = make([]*KeyInfo, 0, batch)
+			if a.stopped() {
+				a.stopAt("", file.lines)
+				break
+			}
+		}
+	}
+	if len(infos) > 0 {
+		keysChan <- infos
+	}
+	close(keysChan)
+	log.Printf("read key file finish, total %d keys, filtered out %d keys\n", num, a.FilteredKeyNum())
+}
+
+// keyFile read keys of KeyFile matching Match line by line
+type keyFile struct {
+	file     *os.File
+	scanner  *bufio.Scanner
+	mode     escape.Mode
+	unescape bool // lines of key file are escaped if keys may contain newlines
+	match    *keyFilter
+	lines    uint64 // lines read
+}
+
+func (a *Analyzer) openKeyFile() *keyFile {
+	file, err := os.Open(a.KeyFile)
+	errorJudge("open key file", err)
+	var match []string
+	if a.Match != "" && a.Match != "*" {
+		match = []string{a.Match}
+	}
+	matchFilter, err := newKeyFilter(match, nil)
+	errorJudge("parse match pattern", err)
+	mode := a.EscapeMode()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 512*1024*1024)
+	return &keyFile{file: file, scanner: scanner, mode: mode, unescape: mode != escape.Auto && mode != escape.Raw,
+		match: matchFilter}
+}
+
+// next return the next key matching Match, false at the end of file
+func (f *keyFile) next() (string, bool) {
+	for f.scanner.Scan() {
+		key := f.scanner.Text()
+		f.lines++
+		if f.unescape && key != "" {
+			line := key
+			var err error
+			key, err = f.mode.Unescape(line)
+			errorJudge("unescape key "+line, err)
+		}
+		if key != "" && f.match.Match(key) {
+			return key, true
+		}
+	}
+	errorJudge("read key file", f.scanner.Err())
+	return "", false
+}
+
+func (f *keyFile) Close() {
+	f.file.Close()
+}
+
+func discoverBackends(discover string) ([]string, error) {
+	switch {
+	case strings.HasPrefix(discover, DiscoverCodis):
+		return discoverCodis(strings.TrimPrefix(discover, DiscoverCodis))
+	case strings.HasPrefix(discover, DiscoverTwemproxy):
+		return discoverTwemproxy(strings.TrimPrefix(discover, DiscoverTwemproxy))
+	case strings.HasPrefix(discover, DiscoverEnvoy):
+		return discoverEnvoy(strings.TrimPrefix(discover, DiscoverEnvoy))
+	}
+	return nil, fmt.Errorf("unknown discover %q", discover)
+}
+
+// discoverCodis return the master of every group from /topom of codis dashboard
+func discoverCodis(address string) ([]string, error) {
+	body, err := httpGet("http://" + address + "/topom")
+	if err != nil {
+		return nil, err
+	}
+	var topom struct {
+		Stats struct {
+			Group struct {
+				Models []struct {
+					Servers []struct {
+						Server string `json:"server"`
+					} `json:"servers"`
+				} `json:"models"`
+			} `json:"group"`
+		} `json:"stats"`
+	}
+	if err = json.Unmarshal(body, &topom); err != nil {
+		return nil, fmt.Errorf("parse codis topom: %v", err)
+	}
+	var backends []string
+	for _, group := range topom.Stats.Group.Models {
+		if len(group.Servers) > 0 { // the first server is master
+			backends = append(backends, group.Servers[0].Server)
+		}
+	}
+	return backends, nil
+}
+
+// discoverTwemproxy return servers of all pools from twemproxy stats, servers must be named by host:port
+func discoverTwemproxy(address string) ([]string, error) {
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	body, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]interface{})
+	if err = json.Unmarshal(body, &stats); err != nil {
+		return nil, fmt.Errorf("parse twemproxy stats: %v", err)
+	}
+	var backends []string
+	for _, pool := range stats {
+		servers, ok := pool.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name, server := range servers {
+			if _, ok := server.(map[string]interface{}); !ok {
+				continue
+			}
+			// server name is host:port[:weight]
+			parts := strings.Split(name, ":")
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("twemproxy server %q is not named by host:port", name)
+			}
+			backends = append(backends, parts[0]+":"+parts[1])
+		}
+	}
+	return backends, nil
+}
+
+// discoverEnvoy return hosts of the cluster from /clusters of envoy admin, address is host:port/cluster
+func discoverEnvoy(address string) ([]string, error) {
+	parts := strings.SplitN(address, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("envoy discover %q has no cluster name", address)
+	}
+	body, err := httpGet("http://" + parts[0] + "/clusters")
+	if err != nil {
+		return nil, err
+	}
+	// line format: cluster::host:port::stat::value
+	seen := make(map[string]bool)
+	var backends []string
+	for _, line := range strings.Split(string(body), "\n") {
+		fields := strings.Split(line, "::")
+		if len(fields) < 3 || fields[0] != parts[1] || !strings.Contains(fields[1], ":") || seen[fields[1]] {
+			continue
+		}
+		seen[fields[1]] = true
+		backends = append(backends, fields[1])
+	}
+	return backends, nil
+}
+
+func httpGet(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s status:%s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,129 @@
+package analyzer
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		num   uint64
+		sizes []int64
+		want  []uint64
+	}{
+		{100, []int64{1, 1, 1}, []uint64{34, 33, 33}},
+		{100000, []int64{10, 999990}, []uint64{1, 99999}},
+		{10, []int64{0, 5}, []uint64{0, 10}},
+		{5, []int64{0, 0}, []uint64{3, 2}},
+		{0, []int64{3, 4}, []uint64{0, 0}},
+	}
+	for _, c := range cases {
+		got := split(c.num, c.sizes)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("split(%d, %v) = %v, want %v", c.num, c.sizes, got, c.want)
+		}
+	}
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// serveFixture serve the fixture at path, return host:port of the server
+func serveFixture(t *testing.T, path, name string) string {
+	body := readFixture(t, name)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestDiscoverCodis(t *testing.T) {
+	address := serveFixture(t, "/topom", "codis_topom.json")
+	backends, err := discoverBackends(DiscoverCodis + address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// masters only, a group without server is skipped
+	if want := []string{"10.0.0.1:6379", "10.0.0.3:6379"}; !reflect.DeepEqual(backends, want) {
+		t.Errorf("Expected backends %v, got %v", want, backends)
+	}
+}
+
+func TestDiscoverTwemproxy(t *testing.T) {
+	body := readFixture(t, "twemproxy_stats.json")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write(body)
+		conn.Close()
+	}()
+
+	backends, err := discoverBackends(DiscoverTwemproxy + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(backends)
+	// weights of server names are trimmed
+	if want := []string{"10.0.0.1:6379", "10.0.0.2:6380", "10.0.0.3:6379"}; !reflect.DeepEqual(backends, want) {
+		t.Errorf("Expected backends %v, got %v", want, backends)
+	}
+}
+
+func TestDiscoverEnvoy(t *testing.T) {
+	address := serveFixture(t, "/clusters", "envoy_clusters.txt")
+	backends, err := discoverBackends(DiscoverEnvoy + address + "/redis_cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1:6379", "10.0.0.2:6379"}; !reflect.DeepEqual(backends, want) {
+		t.Errorf("Expected backends %v, got %v", want, backends)
+	}
+	if _, err = discoverBackends(DiscoverEnvoy + address); err == nil {
+		t.Errorf("Expected error of envoy discover without cluster name")
+	}
+	if _, err = discoverBackends(DiscoverCodis + address); err == nil {
+		t.Errorf("Expected error of codis discover from envoy admin")
+	}
+}
+
+func TestSourcesBackendDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := listener.Addr().String()
+	listener.Close()
+
+	a := &Analyzer{Backends: []string{down}}
+	if _, err = a.sources(); err == nil || !strings.Contains(err.Error(), down) {
+		t.Errorf("Expected error naming backend %s, got %v", down, err)
+	}
+	a = &Analyzer{Backends: []string{"no-port"}}
+	if _, err = a.sources(); err == nil || !strings.Contains(err.Error(), "no-port") {
+		t.Errorf("Expected error naming backend no-port, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+// DiffEntry is a node whose size or key num changed between snapshots, nodes only in one snapshot count as zero
+// in the other
+type DiffEntry struct {
+	Type      string `json:"type"`
+	Path      string `json:"path"` // full key prefix, empty for root of type
+	Depth     int    `json:"depth"`
+	OldSize   int64  `json:"old_size"`
+	NewSize   int64  `json:"new_size"`
+	OldKeyNum int64  `json:"old_key_num"`
+	NewKeyNum int64  `json:"new_key_num"`
+}
+
+func (e *DiffEntry) SizeDelta() int64 {
+	return e.NewSize - e.OldSize
+}
+
+func (e *DiffEntry) KeyNumDelta() int64 {
+	return e.NewKeyNum - e.OldKeyNum
+}
+
+// Diff compare nodes of the same path of every type, entries are sorted by absolute size delta desc
+func Diff(old, new *analyzer.Snapshot) []*DiffEntry {
+	var entries []*DiffEntry
+	for keyT := analyzer.KeyTypeString; keyT <= analyzer.KeyTypeZset; keyT++ {
+		typeStr := analyzer.KeyTypeToTypeStr[keyT]
+		byPath := make(map[string]*DiffEntry)
+		var order []*DiffEntry
+		collect := func(t *tree.Tree, isNew bool) {
+			var paths []string // paths of ancestors by depth
+			_ = t.Walk(func(n *tree.Node, depth int) error {
+				path := ""
+				if depth > 0 {
+					path = paths[depth-1] + n.Segment
+				}
+				paths = append(paths[:depth], path)
+				e, ok := byPath[path]
+				if !ok {
+					e = &DiffEntry{Type: typeStr, Path: path, Depth: depth}
+					byPath[path] = e
+					order = append(order, e)
+				}
+				if isNew {
+					e.NewSize, e.NewKeyNum = n.Size, n.KeyNum
+				} else {
+					e.OldSize, e.OldKeyNum = n.Size, n.KeyNum
+				}
+				return nil
+			})
+		}
+		collect(old.Tree(keyT), false)
+		collect(new.Tree(keyT), true)
+		for _, e := range order {
+			if e.SizeDelta() != 0 || e.KeyNumDelta() != 0 {
+				entries = append(entries, e)
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return abs(entries[i].SizeDelta()) > abs(entries[j].SizeDelta())
+	})
+	return entries
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// WriteDiff write changes of types and the biggest changes of prefixes from old to new.
+// TopN limit the prefixes, MinSize is the min absolute size delta and MaxDepth the max depth of them
+func WriteDiff(w io.Writer, old, new *analyzer.Snapshot, opts TextOptions) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "Summary:")
+	fmt.Fprintf(bw, "  %-8s %12s %12s %12s %8s %12s %12s\n", "type", "old size", "new size", "delta", "%delta", "old keys", "new keys")
+	for keyT := analyzer.KeyTypeAll; keyT <= analyzer.KeyTypeZset; keyT++ {
+		typeStr := analyzer.KeyTypeAllStr
+		if keyT != analyzer.KeyTypeAll {
+			typeStr = analyzer.KeyTypeToTypeStr[keyT]
+		}
+		o, n := old.Tree(keyT), new.Tree(keyT)
+		fmt.Fprintf(bw, "  %-8s %12s %12s %12s %8s %12d %12d\n", typeStr, FormatBytes(o.GetTotalSize()),
+			FormatBytes(n.GetTotalSize()), signedBytes(n.GetTotalSize()-o.GetTotalSize()),
+			percent(n.GetTotalSize()-o.GetTotalSize(), o.GetTotalSize()), o.GetKeyNum(), n.GetKeyNum())
+	}
+	if !old.Complete || !new.Complete {
+		fmt.Fprintln(bw, "Incomplete: some snapshot is taken while analyzing or interrupted")
+	}
+
+	fmt.Fprintln(bw, "Changes:")
+	fmt.Fprintf(bw, "%12s %12s %12s %10s  %-8s %s\n", "delta", "old size", "new size", "keys delta", "type", "prefix")
+	shown := 0
+	for _, e := range Diff(old, new) {
+		if e.Depth == 0 || abs(e.SizeDelta()) < opts.MinSize || (opts.MaxDepth > 0 && e.Depth > opts.MaxDepth) {
+			continue
+		}
+		if opts.TopN > 0 && shown >= opts.TopN {
+			break
+		}
+		fmt.Fprintf(bw, "%12s %12s %12s %+10d  %-8s %s\n", signedBytes(e.SizeDelta()), FormatBytes(e.OldSize),
+			FormatBytes(e.NewSize), e.KeyNumDelta(), e.Type, opts.Escape.Escape(e.Path))
+		shown++
+	}
+	return bw.Flush()
+}
+
+// signedBytes format size with sign like +1.50 KB
+func signedBytes(size int64) string {
+	if size > 0 {
+		return "+" + FormatBytes(size)
+	}
+	return FormatBytes(size)
+}
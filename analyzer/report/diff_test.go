@@ -0,0 +1,43 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+func TestDiff(t *testing.T) {
+	old := testSnapshot()
+	k := analyzer.NewKeyTypeTree(tree.ByteRules([]byte(":")))
+	k.AddKey(&analyzer.KeyInfo{Key: "user:1", KeyT: analyzer.KeyTypeString, Size: 10})
+	k.AddKey(&analyzer.KeyInfo{Key: "user:2", KeyT: analyzer.KeyTypeString, Size: 120})
+	k.AddKey(&analyzer.KeyInfo{Key: "user:3", KeyT: analyzer.KeyTypeString, Size: 2048})
+	k.Finish()
+	entries := Diff(old, k.Snapshot())
+	// root and user: of string, user:3, user:2, root and id:\x96 of hash
+	if len(entries) != 6 || entries[0].Path != "" || entries[2].Path != "user:3" || entries[2].OldSize != 0 ||
+		entries[3].Path != "user:2" || entries[3].SizeDelta() != 100 {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteDiff(buf, old, k.Snapshot(), TextOptions{Escape: escape.Hex, TopN: 3}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"  string           30 B      2.13 KB     +2.10 KB 7160.00%            2            3\n",
+		"    +2.00 KB          0 B      2.00 KB         +1  string   757365723a33\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in diff:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "\n") != 13 {
+		t.Errorf("Expected 3 changes:\n%s", out)
+	}
+}
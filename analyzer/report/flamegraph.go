@@ -0,0 +1,231 @@
+package report
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+// frame separator of folded stacks, it is escaped in segments
+const foldedSeparator = ";"
+
+// frameName escape segment as a frame of folded stacks
+func frameName(mode escape.Mode, segment string) string {
+	return strings.Replace(mode.Escape(segment), foldedSeparator, `\x3b`, -1)
+}
+
+// WriteFolded write own size of every node as folded stacks like "string;user:;1 10", which is the input of
+// flamegraph.pl and speedscope, the first frame is the key type. Own size is the size of the key itself,
+// or of keys aggregated by max depth or folding, nodes without own size are omitted
+func WriteFolded(w io.Writer, s *analyzer.Snapshot, mode escape.Mode) error {
+	bw := bufio.NewWriter(w)
+	for keyT := analyzer.KeyTypeString; keyT <= analyzer.KeyTypeZset; keyT++ {
+		t := s.Tree(keyT)
+		var stacks []string // frames of ancestors by depth
+		err := t.Walk(func(n *tree.Node, depth int) error {
+			name := n.Segment
+			if depth > 0 {
+				name = frameName(mode, n.Segment)
+			}
+			stacks = append(stacks[:depth], name)
+			own := n.Size
+			for _, child := range n.Children() {
+				own -= child.Size
+			}
+			if own <= 0 {
+				return nil
+			}
+			_, err := fmt.Fprintf(bw, "%s %d\n", strings.Join(stacks, foldedSeparator), own)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// layout of flame graph
+const (
+	flameWidth       = 1200
+	flamePad         = 10
+	flameFrameHeight = 16
+	flameTitleHeight = 50
+	flameFontSize    = 12
+	flameMinWidth    = 0.1 // frames narrower than it in pixels are omitted with their descendants
+)
+
+// flameFrame is a rect of flame graph, X and Width are fractions of the total size
+type flameFrame struct {
+	Name   string
+	Path   string
+	Depth  int
+	X      float64
+	Width  float64
+	Size   int64
+	KeyNum int64
+}
+
+// WriteFlameGraph write a standalone svg flame graph of all types, whose root is the total of all types.
+// Frames can be clicked to zoom in, and show path, size and key num on hover
+func WriteFlameGraph(w io.Writer, s *analyzer.Snapshot, mode escape.Mode, title string) error {
+	var total, keyNum int64
+	for keyT := analyzer.KeyTypeString; keyT <= analyzer.KeyTypeZset; keyT++ {
+		total += s.Tree(keyT).GetTotalSize()
+		keyNum += s.Tree(keyT).GetKeyNum()
+	}
+	frames := []*flameFrame{{Name: "all", Width: 1, Size: total, KeyNum: keyNum}}
+	maxDepth := 0
+	if total > 0 {
+		x := 0.0
+		for keyT := analyzer.KeyTypeString; keyT <= analyzer.KeyTypeZset; keyT++ {
+			root := s.Tree(keyT).Root()
+			frames = appendFrames(frames, mode, root, root.Segment, "", 1, x, total)
+			x += float64(root.Size) / float64(total)
+		}
+	}
+	for _, f := range frames {
+		if f.Depth > maxDepth {
+			maxDepth = f.Depth
+		}
+	}
+	height := flameTitleHeight + (maxDepth+1)*flameFrameHeight + 2*flamePad
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<?xml version="1.0" standalone="no"?>
+<svg version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg" onload="init(evt)">
+<style>
+text { font-family: Verdana, sans-serif; font-size: %dpx; fill: #000; }
+.f:hover rect { stroke: #000; stroke-width: 0.5; cursor: pointer; }
+#title { font-size: 17px; text-anchor: middle; }
+#details, #unzoom { font-size: 12px; }
+#unzoom { cursor: pointer; display: none; }
+</style>
+<rect x="0" y="0" width="100%%" height="100%%" fill="#f8f8f8"/>
+`, flameWidth, height, flameWidth, height, flameFontSize)
+	fmt.Fprintf(bw, `<text id="title" x="%d" y="24">%s</text>
+<text id="unzoom" x="%d" y="24">Reset Zoom</text>
+<text id="details" x="%d" y="%d"> </text>
+`, flameWidth/2, xmlText(title), flamePad, flamePad, height-flamePad/2)
+	for _, f := range frames {
+		x := flamePad + f.X*(flameWidth-2*flamePad)
+		width := f.Width * (flameWidth - 2*flamePad)
+		y := height - flamePad - (f.Depth+1)*flameFrameHeight - flameFrameHeight/2
+		info := fmt.Sprintf("%s (%s, %.2f%%, %d keys)", f.Path, FormatBytes(f.Size), f.Width*100, f.KeyNum)
+		if f.Depth == 0 {
+			info = fmt.Sprintf("all (%s, %d keys)", FormatBytes(f.Size), f.KeyNum)
+		}
+		fmt.Fprintf(bw, `<g class="f" data-x="%g" data-w="%g" data-d="%d" data-name="%s" onclick="zoom(this)" onmouseover="details(this)" onmouseout="details(null)">
+<title>%s</title><rect x="%.2f" y="%d" width="%.2f" height="%d" rx="2" fill="%s"/><text x="%.2f" y="%d">%s</text></g>
+`, f.X, f.Width, f.Depth, xmlText(f.Name), xmlText(info), x, y, width, flameFrameHeight-1, flameColor(f.Path),
+			x+3, y+flameFrameHeight-4, xmlText(fitText(f.Name, width)))
+	}
+	fmt.Fprintf(bw, `<script type="text/ecmascript"><![CDATA[
+var W = %d, PAD = %d, CHAR = %g, frames, unzoom, detail;
+function init(evt) {
+	frames = document.getElementsByClassName("f");
+	unzoom = document.getElementById("unzoom");
+	detail = document.getElementById("details").firstChild;
+	unzoom.onclick = function() { zoom(frames[0]); };
+}
+function fit(name, width) {
+	var n = Math.floor((width - 6) / CHAR);
+	if (n < 3) return "";
+	return name.length <= n ? name : name.substring(0, n - 2) + "..";
+}
+function zoom(g) {
+	var zx = +g.dataset.x, zw = +g.dataset.w, zd = +g.dataset.d;
+	unzoom.style.display = zw < 1 ? "block" : "none";
+	for (var i = 0; i < frames.length; i++) {
+		var f = frames[i], x = +f.dataset.x, w = +f.dataset.w, d = +f.dataset.d;
+		var rect = f.getElementsByTagName("rect")[0], text = f.getElementsByTagName("text")[0];
+		if (d < zd) { // ancestors take the whole width
+			if (x <= zx && x + w >= zx + zw) { x = zx; w = zw; } else { f.style.display = "none"; continue; }
+		} else if (x < zx - 1e-12 || x + w > zx + zw + 1e-12) {
+			f.style.display = "none";
+			continue;
+		}
+		f.style.display = "block";
+		var px = PAD + (x - zx) / zw * (W - 2 * PAD), pw = w / zw * (W - 2 * PAD);
+		rect.setAttribute("x", px);
+		rect.setAttribute("width", pw);
+		text.setAttribute("x", px + 3);
+		text.textContent = fit(f.dataset.name, pw);
+	}
+}
+function details(g) {
+	detail.nodeValue = g ? g.getElementsByTagName("title")[0].textContent : " ";
+}
+]]></script>
+</svg>
+`, flameWidth, flamePad, float64(flameFontSize)*0.6)
+	return bw.Flush()
+}
+
+// appendFrames append frames of n and its descendants, x is the fraction where n starts
+func appendFrames(frames []*flameFrame, mode escape.Mode, n *tree.Node, name, path string, depth int, x float64, total int64) []*flameFrame {
+	width := float64(n.Size) / float64(total)
+	if width*(flameWidth-2*flamePad) < flameMinWidth {
+		return frames
+	}
+	frames = append(frames, &flameFrame{Name: name, Path: mode.Escape(path), Depth: depth, X: x, Width: width, Size: n.Size, KeyNum: n.KeyNum})
+	if depth == 1 {
+		frames[len(frames)-1].Path = name
+	}
+	for _, child := range n.Children() {
+		frames = appendFrames(frames, mode, child, mode.Escape(child.Segment), path+child.Segment, depth+1, x, total)
+		x += float64(child.Size) / float64(total)
+	}
+	return frames
+}
+
+// flameColor return a warm color decided by path, so that colors are stable between graphs
+func flameColor(path string) string {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	v := h.Sum32()
+	return fmt.Sprintf("rgb(%d,%d,%d)", 205+v%50, (v>>8)%190, (v>>16)%55)
+}
+
+// fitText truncate text to fit width in pixels like fit in script
+func fitText(text string, width float64) string {
+	n := int((width - 6) / (flameFontSize * 0.6))
+	if n < 3 {
+		return ""
+	}
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-2]) + ".."
+}
+
+// xmlText escape s for text and attributes of xml
+func xmlText(s string) string {
+	b := &strings.Builder{}
+	_ = xml.EscapeText(b, []byte(s))
+	return b.String()
+}
+
+// FormatBytes format size with binary units, e.g. 1.50 KB
+func FormatBytes(size int64) string {
+	const unit = 1024
+	if size < unit && size > -unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	units := []string{"KB", "MB", "GB", "TB", "PB"}
+	i := -1
+	for (value >= unit || value <= -unit) && i < len(units)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.2f %s", value, units[i])
+}
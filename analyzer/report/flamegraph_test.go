@@ -0,0 +1,57 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+func TestFolded(t *testing.T) {
+	k := analyzer.NewKeyTypeTree(tree.ByteRules([]byte(":")))
+	k.AddKey(&analyzer.KeyInfo{Key: "user:1", KeyT: analyzer.KeyTypeString, Size: 10})
+	k.AddKey(&analyzer.KeyInfo{Key: "user:2;x", KeyT: analyzer.KeyTypeString, Size: 20})
+	k.AddKey(&analyzer.KeyInfo{Key: "id:\x96", KeyT: analyzer.KeyTypeHash, Size: 5})
+	k.Finish()
+	buf := &bytes.Buffer{}
+	if err := WriteFolded(buf, k.Snapshot(), escape.Auto); err != nil {
+		t.Fatal(err)
+	}
+	want := "string;user:;1 10\nstring;user:;2\\x3bx 20\nhash;\"id:\\x96\" 5\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestFlameGraph(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteFlameGraph(buf, testSnapshot(), escape.Auto, "test <&>"); err != nil {
+		t.Fatal(err)
+	}
+	// valid xml with frames of all, string, user:, 1, 2, hash and id:\x96
+	decoder := xml.NewDecoder(buf)
+	frames := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, ok := token.(xml.StartElement); ok && e.Name.Local == "g" {
+			frames++
+		}
+	}
+	if frames != 7 {
+		t.Errorf("Expected 7 frames, got %d", frames)
+	}
+	if !strings.Contains(FormatBytes(1536), "1.50 KB") {
+		t.Errorf("Unexpected format %s", FormatBytes(1536))
+	}
+}
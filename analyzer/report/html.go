@@ -0,0 +1,292 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+// nodes smaller than it as a fraction of the total size are left out of the treemap, they are counted in
+// their parent
+const treemapMinFraction = 0.0002
+
+// treemapNode is a node of the treemap embedded as json, names are short to keep the report small
+type treemapNode struct {
+	Name     string         `json:"n"`
+	Path     string         `json:"p"`
+	Type     string         `json:"t"`
+	Size     int64          `json:"s"`
+	KeyNum   int64          `json:"k"`
+	Children []*treemapNode `json:"c,omitempty"`
+}
+
+type htmlTTLRow struct {
+	Name     string
+	KeyNum   int64
+	Size     int64
+	KeyShare float64
+}
+
+type htmlData struct {
+	Title   string
+	Report  *Report
+	Tree    *treemapNode
+	BigKeys []*analyzer.BigKey
+	TTL     []*htmlTTLRow
+}
+
+// WriteHTML write a single file html report of snapshot, which needs no network access to view:
+// a squarified treemap of prefixes of all types, summary of types, the biggest keys and TTL stats if collected
+func WriteHTML(w io.Writer, s *analyzer.Snapshot, meta Meta, filteredKeyNum int64) error {
+	r := New(s, meta, filteredKeyNum)
+	data := &htmlData{
+		Title:  fmt.Sprintf("rma report of %s", r.Meta.Source),
+		Report: r,
+		Tree:   &treemapNode{Name: analyzer.KeyTypeAllStr, Size: r.Stats.TotalSize, KeyNum: r.Stats.KeyNum},
+	}
+	for keyT := analyzer.KeyTypeString; keyT <= analyzer.KeyTypeZset; keyT++ {
+		t := s.Tree(keyT)
+		if node := r.treemapNode(t.Root(), t.Root().Segment, "", t.Root().Segment, r.Stats.TotalSize); node != nil {
+			data.Tree.Children = append(data.Tree.Children, node)
+		}
+	}
+	for _, key := range s.BigKeys(analyzer.KeyTypeAll) {
+		escaped := *key
+		escaped.Key = r.mode.Escape(key.Key)
+		data.BigKeys = append(data.BigKeys, &escaped)
+	}
+	if stats := s.TTLStats(analyzer.KeyTypeAll); stats != nil {
+		var keyNum int64
+		for _, bucket := range stats.Buckets {
+			keyNum += bucket.KeyNum
+		}
+		for _, bucket := range stats.Buckets {
+			row := &htmlTTLRow{Name: bucket.Name, KeyNum: bucket.KeyNum, Size: bucket.Size}
+			if keyNum > 0 {
+				row.KeyShare = float64(bucket.KeyNum) / float64(keyNum)
+			}
+			data.TTL = append(data.TTL, row)
+		}
+	}
+	return htmlTemplate.Execute(w, data)
+}
+
+// treemapNode convert n and its descendants not smaller than treemapMinFraction of total, nil if n is too small
+func (r *Report) treemapNode(n *tree.Node, name, path, typeStr string, total int64) *treemapNode {
+	if n.Size <= 0 || float64(n.Size) < float64(total)*treemapMinFraction {
+		return nil
+	}
+	node := &treemapNode{Name: name, Path: r.mode.Escape(path), Type: typeStr, Size: n.Size, KeyNum: n.KeyNum}
+	if path == "" { // root of type
+		node.Path = typeStr
+	}
+	for _, child := range n.Children() {
+		if c := r.treemapNode(child, r.mode.Escape(child.Segment), path+child.Segment, typeStr, total); c != nil {
+			node.Children = append(node.Children, c)
+		}
+	}
+	return node
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": FormatBytes,
+	"time": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	},
+	"percent": percent,
+	"rank": func(i int) int {
+		return i + 1
+	},
+	"share": func(f float64) string {
+		return fmt.Sprintf("%.2f%%", f*100)
+	},
+	"ttl": func(ms int64) string {
+		switch {
+		case ms == 0:
+			return "-"
+		case ms < 0:
+			return "no expiry"
+		default:
+			return (time.Duration(ms) * time.Millisecond).Round(time.Second).String()
+		}
+	},
+}).Parse(htmlSource))
+
+const htmlSource = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Verdana, sans-serif; font-size: 13px; margin: 20px; color: #222; background: #fafafa; }
+h1 { font-size: 20px; }
+h2 { font-size: 16px; margin-top: 28px; }
+table { border-collapse: collapse; background: #fff; }
+th, td { border: 1px solid #ddd; padding: 4px 10px; text-align: right; }
+th { background: #f0f0f0; }
+td.l, th.l { text-align: left; }
+td.key { font-family: monospace; max-width: 720px; overflow-wrap: anywhere; }
+.meta td { text-align: left; }
+.warn { color: #b00; }
+#crumbs { margin: 8px 0; }
+#crumbs a { color: #06c; cursor: pointer; }
+#map { position: relative; height: 600px; background: #fff; border: 1px solid #ccc; overflow: hidden; }
+.cell { position: absolute; box-sizing: border-box; border: 1px solid #fff; overflow: hidden; cursor: pointer; }
+.cell:hover { border-color: #000; }
+.cell .label { padding: 2px 4px; white-space: nowrap; font-size: 12px; color: #111; }
+.cell.own { cursor: default; background-image: repeating-linear-gradient(45deg, transparent, transparent 4px, rgba(255,255,255,.4) 4px, rgba(255,255,255,.4) 8px); }
+.t-string { background: #8dd3c7; } .t-list { background: #fdb462; } .t-set { background: #bebada; }
+.t-hash { background: #fb8072; } .t-zset { background: #80b1d3; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{with .Report}}
+<table class="meta">
+<tr><th class="l">Source</th><td>{{.Meta.Source}}</td></tr>
+{{if .Meta.StartTime}}<tr><th class="l">Start</th><td>{{time .Meta.StartTime}}</td></tr>{{end}}
+<tr><th class="l">End</th><td>{{time .Meta.EndTime}}{{if not .Meta.Complete}} <span class="warn">(incomplete)</span>{{end}}</td></tr>
+{{if .Meta.Cursors}}<tr><th class="l">Interrupted</th><td class="warn">{{range .Meta.Cursors}}{{.}}<br>{{end}}</td></tr>{{end}}
+<tr><th class="l">Size mode</th><td>{{.Meta.SizeMode}}</td></tr>
+{{if .Stats.Sampling}}<tr><th class="l">Sampling</th><td>{{.Stats.Sampling.SampleNum}} of {{.Stats.Sampling.Population}} keys, sizes are of sampled keys</td></tr>{{end}}
+<tr><th class="l">Keys</th><td>{{.Stats.KeyNum}}{{if .Stats.FilteredKeyNum}} ({{.Stats.FilteredKeyNum}} filtered){{end}}</td></tr>
+<tr><th class="l">Total size</th><td>{{bytes .Stats.TotalSize}}</td></tr>
+</table>
+
+<h2>Types</h2>
+<table>
+<tr><th class="l">Type</th><th>Keys</th><th>Size</th><th>Size %</th><th>Nodes</th></tr>
+{{$total := .Stats.TotalSize}}{{range .Types}}<tr><td class="l"><span class="t-{{.Type}}">&nbsp;&nbsp;</span> {{.Type}}</td><td>{{.KeyNum}}</td><td>{{bytes .TotalSize}}</td><td>{{percent .TotalSize $total}}</td><td>{{.NodeNum}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>Treemap</h2>
+<div>Click a prefix to zoom in, hover for details. Hatched cells are keys of the prefix itself and prefixes too small to show.</div>
+<div id="crumbs"></div>
+<div id="map"></div>
+
+{{if .BigKeys}}
+<h2>Biggest keys</h2>
+<table>
+<tr><th>#</th><th class="l">Key</th><th class="l">Type</th><th>Size</th><th>TTL</th></tr>
+{{range $i, $key := .BigKeys}}<tr><td>{{rank $i}}</td><td class="l key">{{$key.Key}}</td><td class="l">{{$key.Type}}</td><td>{{bytes $key.Size}}</td><td>{{ttl $key.TTL}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .TTL}}
+<h2>TTL</h2>
+<table>
+<tr><th class="l">TTL</th><th>Keys</th><th>Keys %</th><th>Size</th><th>Size %</th></tr>
+{{$total := .Report.Stats.TotalSize}}{{range .TTL}}<tr><td class="l">{{.Name}}</td><td>{{.KeyNum}}</td><td>{{share .KeyShare}}</td><td>{{bytes .Size}}</td><td>{{percent .Size $total}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<script>
+var root = {{.Tree}};
+var map = document.getElementById("map"), crumbs = document.getElementById("crumbs"), stack = [root];
+
+function fmt(size) {
+	var units = ["B", "KB", "MB", "GB", "TB", "PB"], i = 0;
+	while (Math.abs(size) >= 1024 && i < units.length - 1) { size /= 1024; i++; }
+	return i == 0 ? size + " B" : size.toFixed(2) + " " + units[i];
+}
+
+// worst aspect ratio of a row of areas laid along side
+function worst(row, side) {
+	var sum = 0, max = 0, min = Infinity;
+	for (var i = 0; i < row.length; i++) {
+		sum += row[i].a; max = Math.max(max, row[i].a); min = Math.min(min, row[i].a);
+	}
+	return Math.max(side * side * max / (sum * sum), sum * sum / (side * side * min));
+}
+
+// squarify lay out nodes sorted by size desc in the rect, see Bruls, Huizing and van Wijk, Squarified Treemaps
+function squarify(nodes, x, y, w, h) {
+	var total = 0, rects = [];
+	for (var i = 0; i < nodes.length; i++) total += nodes[i].s;
+	if (total <= 0) return rects;
+	var rest = nodes.map(function(n) { return {n: n, a: n.s * w * h / total}; });
+	while (rest.length && w > 0 && h > 0) {
+		var side = Math.min(w, h), row = [rest[0]], k = 1;
+		while (k < rest.length && worst(row.concat([rest[k]]), side) <= worst(row, side)) row.push(rest[k++]);
+		var area = 0;
+		for (var i = 0; i < row.length; i++) area += row[i].a;
+		var thick = area / side, off = 0;
+		for (var i = 0; i < row.length; i++) {
+			var len = row[i].a / thick;
+			if (w >= h) rects.push({n: row[i].n, x: x, y: y + off, w: thick, h: len});
+			else rects.push({n: row[i].n, x: x + off, y: y, w: len, h: thick});
+			off += len;
+		}
+		if (w >= h) { x += thick; w -= thick; } else { y += thick; h -= thick; }
+		rest = rest.slice(k);
+	}
+	return rects;
+}
+
+// children of n with a pseudo child for size not in children, sorted by size desc
+function parts(n) {
+	var children = (n.c || []).slice(), own = n.s;
+	for (var i = 0; i < children.length; i++) own -= children[i].s;
+	if (own > 0 && children.length) children.push({n: "", p: n.p, t: n.t, s: own, k: 0, own: true});
+	children.sort(function(a, b) { return b.s - a.s; });
+	return children;
+}
+
+function cell(parent, r, total, depth) {
+	var n = r.n, div = document.createElement("div");
+	div.className = "cell t-" + (n.t || "string") + (n.own ? " own" : "");
+	div.style.left = r.x + "px"; div.style.top = r.y + "px";
+	div.style.width = r.w + "px"; div.style.height = r.h + "px";
+	div.title = (n.own ? n.p + " (own and small prefixes)" : n.p) + "\n" + fmt(n.s) + ", " +
+		(100 * n.s / total).toFixed(2) + "%" + (n.own ? "" : ", " + n.k + " keys");
+	if (r.w > 40 && r.h > 16) {
+		var label = document.createElement("div");
+		label.className = "label";
+		label.textContent = (n.own ? "" : n.n + " ") + fmt(n.s);
+		div.appendChild(label);
+	}
+	if (!n.own && n.c) {
+		div.onclick = function(e) { e.stopPropagation(); zoom(n); };
+		// nest one more level in big enough cells
+		if (depth == 0 && r.w > 60 && r.h > 50) {
+			var inner = squarify(parts(n), 2, 18, r.w - 6, r.h - 22);
+			for (var i = 0; i < inner.length; i++) cell(div, inner[i], total, depth + 1);
+		}
+	}
+	parent.appendChild(div);
+}
+
+function zoom(n) {
+	var i = stack.indexOf(n);
+	if (i >= 0) stack = stack.slice(0, i + 1);
+	else stack.push(n);
+	render();
+}
+
+function render() {
+	var n = stack[stack.length - 1];
+	map.innerHTML = "";
+	crumbs.innerHTML = "";
+	stack.forEach(function(s, i) {
+		if (i > 0) crumbs.appendChild(document.createTextNode(" > "));
+		var a = document.createElement(i == stack.length - 1 ? "b" : "a");
+		a.textContent = i == 0 ? s.n : s.p;
+		a.onclick = function() { zoom(s); };
+		crumbs.appendChild(a);
+	});
+	crumbs.appendChild(document.createTextNode("  " + fmt(n.s) + ", " + n.k + " keys"));
+	var rects = squarify(parts(n), 0, 0, map.clientWidth, map.clientHeight);
+	for (var i = 0; i < rects.length; i++) cell(map, rects[i], root.s, 0);
+}
+
+window.onresize = render;
+render();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,34 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/iccolo/rma/analyzer"
+)
+
+func TestHTML(t *testing.T) {
+	a := &analyzer.Analyzer{Host: "127.0.0.1", Port: 6379, Separators: ":"}
+	buf := &bytes.Buffer{}
+	if err := WriteHTML(buf, testSnapshot(), NewMeta(a), 0); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"<title>rma report of 127.0.0.1:6379</title>",
+		`"p":"user:2","t":"string","s":20,"k":1`,
+		`<td class="l key">&#34;id:\x96&#34;</td>`,
+		"<td>35 B</td>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in html", want)
+		}
+	}
+	if strings.Contains(out, "<h2>TTL</h2>") {
+		t.Errorf("Expected no TTL stats without TTL")
+	}
+	if strings.Contains(out, "http://") || strings.Contains(out, "https://") || strings.Contains(out, "src=") {
+		t.Errorf("Expected no external resources")
+	}
+}
@@ -0,0 +1,234 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+// SchemaVersion is the version of json and jsonl schema, it is increased only on incompatible changes,
+// fields may be added without increasing it. Sizes are in bytes, times in RFC 3339,
+// segments, paths and sources are escaped by Meta.Escape
+const SchemaVersion = 1
+
+// Report is the json output of a whole analysis
+type Report struct {
+	SchemaVersion int            `json:"schema_version"`
+	Meta          Meta           `json:"meta"`
+	Stats         Stats          `json:"stats"`
+	Types         []*TypeSummary `json:"types"` // in order of key type
+	Trees         []*TypeTree    `json:"trees"` // in order of key type
+
+	mode escape.Mode
+}
+
+// Meta describe how the analysis run
+type Meta struct {
+	Source         string             `json:"source"` // instance or key file, sources separated by comma if merged
+	Separators     string             `json:"separators,omitempty"`
+	SeparatorRules string             `json:"separator_rules,omitempty"`
+	Types          string             `json:"types,omitempty"` // key types analyzed, all types if empty
+	Match          string             `json:"match,omitempty"`
+	Include        []string           `json:"include,omitempty"`
+	Exclude        []string           `json:"exclude,omitempty"`
+	SizeMode       string             `json:"size_mode"`
+	Sample         uint64             `json:"sample,omitempty"`
+	MaxDepth       int                `json:"max_depth,omitempty"`
+	FoldSize       int64              `json:"fold_size,omitempty"`
+	FoldKeyNum     int64              `json:"fold_key_num,omitempty"`
+	Escape         string             `json:"escape"`
+	StartTime      *time.Time         `json:"start_time,omitempty"`
+	EndTime        time.Time          `json:"end_time"`          // time of snapshot
+	Complete       bool               `json:"complete"`          // false if the analysis is still running or interrupted
+	Cursors        []*analyzer.Cursor `json:"cursors,omitempty"` // where sources stopped if interrupted
+}
+
+// Stats of all types
+type Stats struct {
+	KeyNum         int64          `json:"key_num"`
+	TotalSize      int64          `json:"total_size"`
+	NodeNum        int64          `json:"node_num"`
+	MaxDepth       int            `json:"max_depth"` // depth of the deepest node, root is at depth 0
+	FilteredKeyNum int64          `json:"filtered_key_num"`
+	Sampling       *tree.Sampling `json:"sampling,omitempty"` // only for sampling analysis
+	Estimate       *tree.Estimate `json:"estimate,omitempty"` // only for sampling analysis
+}
+
+// TypeSummary is the stats of a key type
+type TypeSummary struct {
+	Type      string         `json:"type"`
+	KeyNum    int64          `json:"key_num"`
+	TotalSize int64          `json:"total_size"`
+	NodeNum   int64          `json:"node_num"`
+	Estimate  *tree.Estimate `json:"estimate,omitempty"` // only for sampling analysis
+}
+
+// TypeTree is the tree of a key type, whose root has empty path
+type TypeTree struct {
+	Type string `json:"type"`
+	Root *Node  `json:"root"`
+}
+
+// NodeStat is the stats of a node shared by json and jsonl
+type NodeStat struct {
+	Segment  string            `json:"segment"`
+	Path     string            `json:"path"` // full key prefix
+	KeyNum   int64             `json:"key_num"`
+	Size     int64             `json:"size"`
+	ChildNum int               `json:"child_num"`
+	Estimate *tree.Estimate    `json:"estimate,omitempty"` // only for sampling analysis
+	Sources  []tree.SourceStat `json:"sources,omitempty"`  // only for trees merged from sources
+}
+
+// Node of json, children are sorted by segment
+type Node struct {
+	NodeStat
+	Children []*Node `json:"children,omitempty"`
+}
+
+// Record is a line of jsonl, records of a type are in the order of tree.Tree.Walk
+type Record struct {
+	SchemaVersion int    `json:"schema_version"`
+	Type          string `json:"type"`
+	Depth         int    `json:"depth"`
+	Leaf          bool   `json:"leaf"`
+	NodeStat
+}
+
+// NewMeta return meta of analyzer, times are set by caller
+func NewMeta(a *analyzer.Analyzer) Meta {
+	return Meta{
+		Source:         a.SourceName(),
+		Separators:     a.Separators,
+		SeparatorRules: a.Rules,
+		Types:          a.Types,
+		Match:          a.Match,
+		Include:        a.Include,
+		Exclude:        a.Exclude,
+		SizeMode:       a.GetSizeMode(),
+		Sample:         a.Sample,
+		MaxDepth:       a.MaxDepth,
+		FoldSize:       a.FoldSize,
+		FoldKeyNum:     a.FoldKeyNum,
+		Escape:         string(a.EscapeMode()),
+	}
+}
+
+// New return report of snapshot, meta.Escape decide how keys are escaped
+func New(s *analyzer.Snapshot, meta Meta, filteredKeyNum int64) *Report {
+	mode, err := escape.Parse(meta.Escape)
+	if err != nil {
+		mode = escape.Auto
+	}
+	meta.Escape = string(mode)
+	meta.EndTime = s.Time
+	meta.Complete = s.Complete
+	meta.Cursors = s.Cursors
+	if sources := s.Tree(analyzer.KeyTypeString).Sources(); len(sources) > 1 {
+		meta.Source = strings.Join(sources, ",")
+	}
+	r := &Report{
+		SchemaVersion: SchemaVersion,
+		Meta:          meta,
+		Stats:         Stats{FilteredKeyNum: filteredKeyNum, Sampling: s.GetSampling()},
+		mode:          mode,
+	}
+	for keyT := analyzer.KeyTypeString; keyT <= analyzer.KeyTypeZset; keyT++ {
+		t := s.Tree(keyT)
+		typeStr := analyzer.KeyTypeToTypeStr[keyT]
+		root := r.node(t, t.Root(), "")
+		summary := &TypeSummary{Type: typeStr, KeyNum: t.GetKeyNum(), TotalSize: t.GetTotalSize()}
+		if r.Stats.Sampling != nil {
+			summary.Estimate = t.Estimate()
+		}
+		_ = t.Walk(func(n *tree.Node, depth int) error {
+			summary.NodeNum++
+			if depth > r.Stats.MaxDepth {
+				r.Stats.MaxDepth = depth
+			}
+			return nil
+		})
+		r.Stats.KeyNum += summary.KeyNum
+		r.Stats.TotalSize += summary.TotalSize
+		r.Stats.NodeNum += summary.NodeNum
+		r.Types = append(r.Types, summary)
+		r.Trees = append(r.Trees, &TypeTree{Type: typeStr, Root: root})
+	}
+	if r.Stats.Sampling != nil {
+		r.Stats.Estimate = s.Tree(analyzer.KeyTypeAll).Estimate()
+	}
+	return r
+}
+
+// node convert n with path to json node recursively
+func (r *Report) node(t *tree.Tree, n *tree.Node, path string) *Node {
+	node := &Node{NodeStat: r.nodeStat(t, n, path)}
+	for _, child := range n.Children() {
+		node.Children = append(node.Children, r.node(t, child, path+child.Segment))
+	}
+	return node
+}
+
+func (r *Report) nodeStat(t *tree.Tree, n *tree.Node, path string) NodeStat {
+	stat := NodeStat{
+		Segment:  r.mode.Escape(n.Segment),
+		Path:     r.mode.Escape(path),
+		KeyNum:   n.KeyNum,
+		Size:     n.Size,
+		ChildNum: n.ChildNum,
+	}
+	if path == "" { // segment of root is the type
+		stat.Segment = n.Segment
+	}
+	if sampling := t.GetSampling(); sampling != nil {
+		stat.Estimate = sampling.Estimate(n)
+	}
+	if len(t.Sources()) > 1 {
+		stat.Sources = n.Sources()
+		for i := range stat.Sources {
+			stat.Sources[i].Source = r.mode.Escape(stat.Sources[i].Source)
+		}
+	}
+	return stat
+}
+
+// WriteJSON write the whole report as indented json
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteJSONL write a record per node of every type, roots included, without building the whole report
+func WriteJSONL(w io.Writer, s *analyzer.Snapshot, mode escape.Mode) error {
+	r := &Report{mode: mode}
+	encoder := json.NewEncoder(w)
+	for keyT := analyzer.KeyTypeString; keyT <= analyzer.KeyTypeZset; keyT++ {
+		t := s.Tree(keyT)
+		typeStr := analyzer.KeyTypeToTypeStr[keyT]
+		var paths []string // paths of ancestors by depth
+		err := t.Walk(func(n *tree.Node, depth int) error {
+			path := ""
+			if depth > 0 {
+				path = paths[depth-1] + n.Segment
+			}
+			paths = append(paths[:depth], path)
+			return encoder.Encode(&Record{
+				SchemaVersion: SchemaVersion,
+				Type:          typeStr,
+				Depth:         depth,
+				Leaf:          n.IsLeaf(),
+				NodeStat:      r.nodeStat(t, n, path),
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
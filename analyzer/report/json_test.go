@@ -0,0 +1,67 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+func testSnapshot() *analyzer.Snapshot {
+	k := analyzer.NewKeyTypeTree(tree.ByteRules([]byte(":")))
+	k.AddKey(&analyzer.KeyInfo{Key: "user:1", KeyT: analyzer.KeyTypeString, Size: 10})
+	k.AddKey(&analyzer.KeyInfo{Key: "user:2", KeyT: analyzer.KeyTypeString, Size: 20})
+	k.AddKey(&analyzer.KeyInfo{Key: "id:\x96", KeyT: analyzer.KeyTypeHash, Size: 5})
+	k.Finish()
+	return k.Snapshot()
+}
+
+func TestJSON(t *testing.T) {
+	a := &analyzer.Analyzer{Host: "127.0.0.1", Port: 6379, Separators: ":"}
+	buf := &bytes.Buffer{}
+	if err := New(testSnapshot(), NewMeta(a), 3).WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	r := &Report{}
+	if err := json.Unmarshal(buf.Bytes(), r); err != nil {
+		t.Fatal(err)
+	}
+	if r.SchemaVersion != SchemaVersion || r.Meta.Source != "127.0.0.1:6379" || !r.Meta.Complete ||
+		r.Stats.KeyNum != 3 || r.Stats.TotalSize != 35 || r.Stats.FilteredKeyNum != 3 || r.Stats.MaxDepth != 2 {
+		t.Errorf("Unexpected report %+v", r)
+	}
+	if len(r.Types) != 5 || r.Types[0].Type != "string" || r.Types[0].KeyNum != 2 {
+		t.Errorf("Unexpected types %+v", r.Types)
+	}
+	user := r.Trees[0].Root.Children[0]
+	if user.Path != "user:" || len(user.Children) != 2 || user.Children[1].Path != "user:2" || user.Children[1].Size != 20 {
+		t.Errorf("Unexpected node %+v", user)
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteJSONL(buf, testSnapshot(), escape.Hex); err != nil {
+		t.Fatal(err)
+	}
+	var records []*Record
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		record := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	// roots of 5 types, user:, user:1, user:2 and id:\x96
+	if len(records) != 9 {
+		t.Fatalf("Expected 9 records, got %d", len(records))
+	}
+	if r := records[2]; r.Type != "string" || r.Depth != 2 || !r.Leaf || r.Path != "757365723a31" || r.Size != 10 {
+		t.Errorf("Unexpected record %+v", r)
+	}
+}
@@ -0,0 +1,167 @@
+package report
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+// WritePprof write a gzipped pprof profile with values of own size in bytes and own key num,
+// every node is a function named by the type and its full key prefix, so that stacks are paths of keys,
+// see https://github.com/google/pprof/blob/main/proto/profile.proto
+func WritePprof(w io.Writer, s *analyzer.Snapshot, mode escape.Mode, comment string) error {
+	p := &profile{strings: map[string]int64{"": 0}, stringTable: []string{""}}
+	typeKey := p.str("type")
+	for keyT := analyzer.KeyTypeString; keyT <= analyzer.KeyTypeZset; keyT++ {
+		t := s.Tree(keyT)
+		typeStr := analyzer.KeyTypeToTypeStr[keyT]
+		var stack []uint64 // location ids of ancestors by depth, root first
+		var paths []string
+		err := t.Walk(func(n *tree.Node, depth int) error {
+			path := typeStr
+			if depth > 0 {
+				path = paths[depth-1] + n.Segment
+			}
+			paths = append(paths[:depth], path)
+			name := typeStr
+			if depth > 0 {
+				name = typeStr + " " + mode.Escape(path[len(typeStr):])
+			}
+			stack = append(stack[:depth], p.location(name, typeStr))
+
+			own, ownKeyNum := n.Size, n.KeyNum
+			for _, child := range n.Children() {
+				own -= child.Size
+				ownKeyNum -= child.KeyNum
+			}
+			if own <= 0 && ownKeyNum <= 0 {
+				return nil
+			}
+			sample := &protoBuffer{}
+			locations := &protoBuffer{}
+			for i := len(stack) - 1; i >= 0; i-- { // leaf first
+				locations.varint(stack[i])
+			}
+			sample.bytes(1, locations.buf)
+			values := &protoBuffer{}
+			values.varint(uint64(own))
+			values.varint(uint64(ownKeyNum))
+			sample.bytes(2, values.buf)
+			label := &protoBuffer{}
+			label.int64(1, typeKey)
+			label.int64(2, p.str(typeStr))
+			sample.bytes(3, label.buf)
+			p.samples = append(p.samples, sample.buf)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	out := &protoBuffer{}
+	out.bytes(1, valueType(p.str("space"), p.str("bytes")))
+	out.bytes(1, valueType(p.str("keys"), p.str("count")))
+	for _, sample := range p.samples {
+		out.bytes(2, sample)
+	}
+	for _, location := range p.locations {
+		out.bytes(4, location)
+	}
+	for _, function := range p.functions {
+		out.bytes(5, function)
+	}
+	commentIndex := p.str(comment)
+	defaultType := p.str("space")
+	for _, str := range p.stringTable {
+		out.bytes(6, []byte(str))
+	}
+	out.int64(9, s.Time.UnixNano())
+	out.int64(13, commentIndex)
+	out.int64(14, defaultType)
+
+	gw := gzip.NewWriter(w)
+	if _, err := gw.Write(out.buf); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// profile collect messages of pprof profile
+type profile struct {
+	strings     map[string]int64
+	stringTable []string
+	samples     [][]byte
+	locations   [][]byte
+	functions   [][]byte
+}
+
+// str return index of s in string table
+func (p *profile) str(s string) int64 {
+	if i, ok := p.strings[s]; ok {
+		return i
+	}
+	p.stringTable = append(p.stringTable, s)
+	p.strings[s] = int64(len(p.stringTable) - 1)
+	return p.strings[s]
+}
+
+// location add a location with a function of name, every node has its own location, id starts from 1
+func (p *profile) location(name, filename string) uint64 {
+	id := uint64(len(p.locations) + 1)
+	function := &protoBuffer{}
+	function.uint64(1, id)
+	function.int64(2, p.str(name))
+	function.int64(3, p.str(name))
+	function.int64(4, p.str(filename))
+	p.functions = append(p.functions, function.buf)
+
+	line := &protoBuffer{}
+	line.uint64(1, id)
+	location := &protoBuffer{}
+	location.uint64(1, id)
+	location.bytes(4, line.buf)
+	p.locations = append(p.locations, location.buf)
+	return id
+}
+
+func valueType(typ, unit int64) []byte {
+	b := &protoBuffer{}
+	b.int64(1, typ)
+	b.int64(2, unit)
+	return b.buf
+}
+
+// protoBuffer encode protobuf fields used by profile.proto
+type protoBuffer struct {
+	buf []byte
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.buf = append(b.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	b.buf = append(b.buf, byte(v))
+}
+
+func (b *protoBuffer) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(v)
+}
+
+func (b *protoBuffer) int64(field int, v int64) {
+	b.uint64(field, uint64(v))
+}
+
+func (b *protoBuffer) bytes(field int, v []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(v)))
+	b.buf = append(b.buf, v...)
+}
@@ -0,0 +1,35 @@
+package report
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/iccolo/rma/analyzer/escape"
+)
+
+func TestPprof(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WritePprof(buf, testSnapshot(), escape.Auto, "test"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sample types and functions are in string table
+	for _, s := range []string{"space", "bytes", "keys", "count", "string user:", "string user:1", `hash "id:\x96"`} {
+		if !bytes.Contains(data, append([]byte{byte(len(s))}, s...)) {
+			t.Errorf("Expected string %q in profile", s)
+		}
+	}
+	// sample of user:2 is at locations 4, 2, 1 with values 20 bytes and 1 key
+	if !bytes.Contains(data, []byte{0x0a, 3, 4, 2, 1, 0x12, 2, 20, 1}) {
+		t.Errorf("Expected sample of user:2 in profile")
+	}
+}
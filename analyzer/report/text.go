@@ -0,0 +1,171 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+// DefaultBarWidth is the width of bar columns of text report
+const DefaultBarWidth = 10
+
+// TextOptions decide what the text report shows, zero value shows every node
+type TextOptions struct {
+	Escape   escape.Mode
+	TopN     int   // biggest children shown per node, 0 for all
+	MaxDepth int   // nodes deeper than it are not shown, 0 for no limit
+	MinSize  int64 // nodes smaller than it are not shown
+	BarWidth int   // DefaultBarWidth if 0, no bar if negative
+}
+
+// WriteText write a summary of types and their trees for reading, children are sorted by size desc,
+// with percentages of parent and of total and a bar of the percentage of parent.
+// Children hidden by TopN or MinSize are summed in a line
+func WriteText(w io.Writer, s *analyzer.Snapshot, opts TextOptions) error {
+	if opts.BarWidth == 0 {
+		opts.BarWidth = DefaultBarWidth
+	}
+	bw := bufio.NewWriter(w)
+	total := s.Tree(analyzer.KeyTypeAll).GetTotalSize()
+
+	fmt.Fprintln(bw, "Summary:")
+	sampling := s.GetSampling()
+	if sampling != nil {
+		fmt.Fprintf(bw, "  sampled %d of %d keys, z:%.2f\n", sampling.SampleNum, sampling.Population, sampling.Z)
+	}
+	fmt.Fprintf(bw, "  %-8s %12s %12s %8s\n", "type", "keys", "size", "%total")
+	for keyT := analyzer.KeyTypeString; keyT <= analyzer.KeyTypeZset; keyT++ {
+		t := s.Tree(keyT)
+		fmt.Fprintf(bw, "  %-8s %12d %12s %8s", analyzer.KeyTypeToTypeStr[keyT], t.GetKeyNum(),
+			FormatBytes(t.GetTotalSize()), percent(t.GetTotalSize(), total))
+		if sampling != nil {
+			fmt.Fprintf(bw, "  %s", estimateText(t.Estimate()))
+		}
+		fmt.Fprintln(bw)
+	}
+	fmt.Fprintf(bw, "  %-8s %12d %12s\n", analyzer.KeyTypeAllStr, s.Tree(analyzer.KeyTypeAll).GetKeyNum(), FormatBytes(total))
+	if len(s.Cursors) > 0 {
+		fmt.Fprintln(bw, "Incomplete: analysis is interrupted at")
+		for _, c := range s.Cursors {
+			fmt.Fprintf(bw, "  %s\n", c)
+		}
+	} else if !s.Complete {
+		fmt.Fprintln(bw, "Incomplete: analysis is still running")
+	}
+
+	fmt.Fprintln(bw, "Detail:")
+	p := &textPrinter{w: bw, opts: opts, total: total}
+	for keyT := analyzer.KeyTypeString; keyT <= analyzer.KeyTypeZset; keyT++ {
+		t := s.Tree(keyT)
+		if t.GetKeyNum() == 0 {
+			continue
+		}
+		p.t = t
+		p.header()
+		p.print(t.Root(), t.Root().Segment, t.GetTotalSize(), 0)
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// textPrinter print nodes of a tree as rows
+type textPrinter struct {
+	w     io.Writer
+	opts  TextOptions
+	total int64
+	t     *tree.Tree
+}
+
+func (p *textPrinter) header() {
+	fmt.Fprintf(p.w, "%10s %8s %8s ", "size", "%parent", "%total")
+	if p.opts.BarWidth > 0 {
+		fmt.Fprintf(p.w, "%-*s ", p.opts.BarWidth+2, "")
+	}
+	fmt.Fprintf(p.w, "%10s  %s\n", "keys", "prefix")
+}
+
+// print n and its children, parentSize is the size percentage of parent is based on
+func (p *textPrinter) print(n *tree.Node, name string, parentSize int64, depth int) {
+	p.row(FormatBytes(n.Size), n.Size, parentSize, fmt.Sprint(n.KeyNum), depth, name)
+	if sampling := p.t.GetSampling(); sampling != nil {
+		fmt.Fprintf(p.w, "%*s%s\n", p.indent(depth+1), "", estimateText(sampling.Estimate(n)))
+	}
+	if len(p.t.Sources()) > 1 {
+		for _, stat := range n.Sources() {
+			fmt.Fprintf(p.w, "%*s@%s  %s  %d keys\n", p.indent(depth+1), "", p.opts.Escape.Escape(stat.Source),
+				FormatBytes(stat.Size), stat.KeyNum)
+		}
+	}
+	if p.opts.MaxDepth > 0 && depth >= p.opts.MaxDepth {
+		return
+	}
+
+	children := n.Children()
+	sort.SliceStable(children, func(i, j int) bool {
+		return children[i].Size > children[j].Size
+	})
+	var hiddenNum int
+	var hiddenSize, hiddenKeyNum int64
+	for i, child := range children {
+		if (p.opts.TopN > 0 && i >= p.opts.TopN) || child.Size < p.opts.MinSize {
+			hiddenNum++
+			hiddenSize += child.Size
+			hiddenKeyNum += child.KeyNum
+			continue
+		}
+		p.print(child, p.opts.Escape.Escape(child.Segment), n.Size, depth+1)
+	}
+	if hiddenNum > 0 {
+		p.row(FormatBytes(hiddenSize), hiddenSize, n.Size, fmt.Sprint(hiddenKeyNum), depth+1,
+			fmt.Sprintf("... %d more", hiddenNum))
+	}
+}
+
+func (p *textPrinter) row(size string, value, parentSize int64, keyNum string, depth int, name string) {
+	fmt.Fprintf(p.w, "%10s %8s %8s ", size, percent(value, parentSize), percent(value, p.total))
+	if p.opts.BarWidth > 0 {
+		fmt.Fprintf(p.w, "[%s] ", bar(value, parentSize, p.opts.BarWidth))
+	}
+	fmt.Fprintf(p.w, "%10s  %*s%s\n", keyNum, depth*2, "", name)
+}
+
+// indent of lines under a row of depth, which are aligned with the prefix column
+func (p *textPrinter) indent(depth int) int {
+	width := 10 + 1 + 8 + 1 + 8 + 1 + 10 + 2
+	if p.opts.BarWidth > 0 {
+		width += p.opts.BarWidth + 3
+	}
+	return width + depth*2
+}
+
+// percent format part of total like 12.34%
+func percent(part, total int64) string {
+	if total <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", float64(part)*100/float64(total))
+}
+
+// bar of part of total in width, like "####      "
+func bar(part, total int64, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = int(float64(part)*float64(width)/float64(total) + 0.5)
+	}
+	if filled > width {
+		filled = width
+	}
+	return strings.Repeat("#", filled) + strings.Repeat(" ", width-filled)
+}
+
+func estimateText(e *tree.Estimate) string {
+	return fmt.Sprintf("est size:%s [%s, %s] est keys:%.0f [%.0f, %.0f]",
+		FormatBytes(int64(e.Size)), FormatBytes(int64(e.SizeLow)), FormatBytes(int64(e.SizeHigh)),
+		e.KeyNum, e.KeyNumLow, e.KeyNumHigh)
+}
@@ -0,0 +1,53 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+func TestText(t *testing.T) {
+	k := analyzer.NewKeyTypeTree(tree.ByteRules([]byte(":")))
+	for i := 1; i <= 4; i++ {
+		k.AddKey(&analyzer.KeyInfo{Key: fmt.Sprintf("user:%d", i), KeyT: analyzer.KeyTypeString, Size: int64(i * 100)})
+	}
+	k.AddKey(&analyzer.KeyInfo{Key: "order:1", KeyT: analyzer.KeyTypeHash, Size: 1000})
+	k.AddKey(&analyzer.KeyInfo{Key: "order:2", KeyT: analyzer.KeyTypeHash, Size: 1000})
+	k.Finish()
+	buf := &bytes.Buffer{}
+	if err := WriteText(buf, k.Snapshot(), TextOptions{Escape: escape.Auto, TopN: 2}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"  string              4       1000 B   33.33%\n",
+		"    400 B   40.00%   13.33% [####      ]          1      4\n",
+		"    300 B   30.00%   10.00% [###       ]          1      3\n",
+		"    300 B   30.00%   10.00% [###       ]          2      ... 2 more\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in text", want)
+		}
+	}
+	if strings.Index(out, "hash ") > strings.Index(out, "user:") {
+		t.Errorf("Expected summary before detail")
+	}
+	if strings.Contains(out, "Incomplete") {
+		t.Errorf("Expected complete text")
+	}
+
+	interrupted := k.Snapshot()
+	interrupted.Cursors = []*analyzer.Cursor{{Source: "127.0.0.1:6379", Cursor: 42}}
+	buf.Reset()
+	if err := WriteText(buf, interrupted, TextOptions{Escape: escape.Auto}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Incomplete: analysis is interrupted at\n  127.0.0.1:6379 cursor 42\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("Expected %q in text", want)
+	}
+}
@@ -0,0 +1,93 @@
+package analyzer
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// sample pick a.Sample distinct keys by RANDOMKEY instead of SCAN, totals are extrapolated from DBSIZE.
+// Keys dropped by Match, filter or type still count as sampled, they are observations of zero size
+func (a *Analyzer) sample(keysChan chan []*KeyInfo, keyTree *KeyTypeTree, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	conn := a.Dial()
+	defer conn.Close()
+
+	population, err := redigo.Int64(conn.Do("DBSIZE"))
+	errorJudge("redis DBSIZE", err)
+
+	filter, err := newKeyFilter(a.Include, a.Exclude)
+	errorJudge("parse key filter", err)
+	// RANDOMKEY can not MATCH on server side
+	var match []string
+	if a.Match != "" && a.Match != "*" {
+		match = []string{a.Match}
+	}
+	matchFilter, err := newKeyFilter(match, nil)
+	errorJudge("parse match pattern", err)
+
+	target := int64(a.Sample)
+	if target > population {
+		target = population
+	}
+	atomic.AddInt64(&a.counter().progress.total, target)
+	batch := int64(a.Count)
+	if batch == 0 {
+		batch = 1000
+	}
+
+	var (
+		seen    = make(map[string]bool, target)
+		sampled int64
+		tries   int64
+	)
+	// a small key space may not yield enough distinct keys quickly, so limit the total RANDOMKEY calls
+	for sampled < target && tries < target*3 {
+		n := target - sampled
+		if n > batch {
+			n = batch
+		}
+		for i := int64(0); i < n; i++ {
+			err := conn.Send("RANDOMKEY")
+			errorJudge("redis conn Send RANDOMKEY cmd", err)
+		}
+		err := conn.Flush()
+		errorJudge("redis conn Flush", err)
+		tries += n
+
+		infos := make([]*KeyInfo, 0, n)
+		for i := int64(0); i < n; i++ {
+			key, err := redigo.String(conn.Receive())
+			if err == redigo.ErrNil { // empty db
+				continue
+			}
+			errorJudge("redis conn Receive", err)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			sampled++
+			atomic.AddInt64(&a.counter().progress.scanned, 1)
+			if !matchFilter.Match(key) {
+				atomic.AddInt64(&a.counter().progress.dropped, 1)
+				continue
+			}
+			if !filter.empty() && !filter.Match(key) {
+				atomic.AddInt64(&a.counter().filteredKeyNum, 1)
+				continue
+			}
+			infos = append(infos, &KeyInfo{Key: key})
+		}
+		keysChan <- infos
+		if sampled < target && a.stopped() {
+			a.stopAt("", uint64(sampled))
+			break
+		}
+	}
+	keyTree.AddSampling(population, sampled, a.Confidence)
+	close(keysChan)
+	log.Printf("sample finish, %d of %d keys, filtered out %d keys\n", sampled, population, a.FilteredKeyNum())
+}
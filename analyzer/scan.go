@@ -1,34 +1,120 @@
 package analyzer
 
 import (
+	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 
 	redigo "github.com/gomodule/redigo/redis"
 )
 
-func (a *Analyzer) scan(keysChan chan []string, wg *sync.WaitGroup) {
+func (a *Analyzer) scan(keysChan chan []*KeyInfo, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	conn := a.Dial()
 	defer conn.Close()
 
-	var (
-		cursor int
-		num    int
-	)
+	filter, err := newKeyFilter(a.Include, a.Exclude)
+	errorJudge("parse key filter", err)
+	a.addTotal(conn)
+
+	var num uint64
+	scanTypes := a.scanTypes(conn)
+	if len(scanTypes) == 0 {
+		num, _ = a.scanPass(conn, filter, keysChan, "", num)
+	}
+	for i, typeStr := range scanTypes {
+		if a.stopped() { // the pass of type is not started
+			a.stopAt(typeStr, 0)
+			continue
+		}
+		var ok bool
+		num, ok = a.scanPass(conn, filter, keysChan, typeStr, num)
+		if !ok && i > 0 {
+			// an untyped pass would repeat keys of types scanned, so the result can not be completed
+			errorJudge("scan with TYPE "+typeStr, fmt.Errorf("rejected after TYPE %s is accepted", scanTypes[0]))
+		}
+		if !ok { // SCAN TYPE rejected before any key was returned, fall back to TYPE for every key
+			log.Printf("scan with TYPE %s rejected, fall back to scan all types\n", typeStr)
+			num, _ = a.scanPass(conn, filter, keysChan, "", num)
+			break
+		}
+		if num >= a.Limit {
+			break
+		}
+	}
+	close(keysChan)
+	if a.stopped() {
+		log.Printf("scan interrupted, total %d keys, filtered out %d keys\n", num, a.FilteredKeyNum())
+		return
+	}
+	log.Printf("scan finish, total %d keys, filtered out %d keys\n", num, a.FilteredKeyNum())
+}
+
+// scanPass scan the whole key space once, filter by key type on server side if typeStr is not empty.
+// return false if server reject SCAN with TYPE option by an error reply, other errors panic
+func (a *Analyzer) scanPass(conn redigo.Conn, filter *keyFilter, keysChan chan []*KeyInfo, typeStr string, num uint64) (uint64, bool) {
+	args := redigo.Args{}.Add("MATCH", a.Match, "COUNT", a.Count)
+	if typeStr != "" {
+		args = args.Add("TYPE", typeStr)
+	}
+	keyT := KeyTypeStrToType[typeStr]
+
+	var cursor int
 	for {
-		results, err := redigo.Values(conn.Do("SCAN", cursor, "MATCH", a.Match, "COUNT", a.Count))
+		results, err := redigo.Values(conn.Do("SCAN", append(redigo.Args{cursor}, args...)...))
+		if _, ok := err.(redigo.Error); ok && typeStr != "" && cursor == 0 {
+			return num, false
+		}
+		if _, ok := err.(redigo.Error); ok && cursor == 0 {
+			errorJudge("scan redis, set backends, discover or key file if behind proxy", err)
+		}
 		errorJudge("scan redis", err)
 		cursor, _ = redigo.Int(results[0], nil)
 		keys, _ := redigo.Strings(results[1], nil)
-		num += len(keys)
+		num += uint64(len(keys))
+		atomic.AddInt64(&a.counter().progress.scanned, int64(len(keys)))
 
-		keysChan <- keys
-		if cursor == 0 || uint64(num) >= a.Limit {
+		infos := make([]*KeyInfo, 0, len(keys))
+		for _, key := range keys {
+			if !filter.empty() && !filter.Match(key) {
+				atomic.AddInt64(&a.counter().filteredKeyNum, 1)
+				continue
+			}
+			infos = append(infos, &KeyInfo{
+				Key:  key,
+				KeyT: keyT,
+			})
+		}
+		keysChan <- infos
+		if cursor == 0 || num >= a.Limit {
+			break
+		}
+		if a.stopped() {
+			a.stopAt(typeStr, uint64(cursor))
 			break
 		}
 	}
-	close(keysChan)
-	log.Printf("scan finish, total %d keys\n", num)
+	return num, true
+}
+
+// scanTypes return the key types to push down to SCAN TYPE, empty if should scan all types.
+// SCAN TYPE is supported since redis 6.0
+func (a *Analyzer) scanTypes(conn redigo.Conn) []string {
+	types := a.keyTypes()
+	if len(types) == len(KeyTypeStrToType) {
+		return nil
+	}
+	if major, _ := serverVersion(conn); major < 6 {
+		log.Printf("redis major version %d not support SCAN TYPE\n", major)
+		return nil
+	}
+	typeStrs := make([]string, 0, len(types))
+	for keyType := KeyTypeString; keyType <= KeyTypeZset; keyType++ {
+		if typeStr := KeyTypeToTypeStr[keyType]; types[typeStr] != 0 {
+			typeStrs = append(typeStrs, typeStr)
+		}
+	}
+	return typeStrs
 }
@@ -0,0 +1,198 @@
+package size
+
+import (
+	"math/bits"
+	"strconv"
+)
+
+// Encoding aware estimation follows the memory layout of redis 6/7 on 64-bit platform,
+// length has the same meaning as List, -1 if members are all elements of the collection
+
+const (
+	dictEntrySize     = 24
+	bucketSize        = 8
+	quicklistSize     = 40
+	quicklistNodeSize = 32
+	quicklistNodeFill = 8 * 1024 // list-max-listpack-size -2
+	skiplistNodeSize  = 48       // score, ele, backward and 1.33 levels on average
+	listpackHeader    = 6 + 1    // total bytes, num elements and end byte
+	intsetHeader      = 8
+)
+
+func StringEncoded(encoding, key string, members [][]byte, length int) int {
+	switch encoding {
+	case "int": // value is stored in the pointer of redis object
+		return keySize(key)
+	case "embstr": // value is allocated together with redis object
+		return keySize(key) + sdsSize(length)
+	case "raw":
+		return keySize(key) + alloc(sdsSize(length))
+	}
+	return String(key, members, length)
+}
+
+func ListEncoded(encoding, key string, members [][]byte, length int) int {
+	switch encoding {
+	case "ziplist", "listpack":
+		return keySize(key) + alloc(listpackHeader+extrapolate(listpackEntries(members), len(members), length))
+	case "quicklist":
+		entries := extrapolate(listpackEntries(members), len(members), length)
+		nodeNum := (entries + quicklistNodeFill - 1) / quicklistNodeFill
+		return keySize(key) + quicklistSize + nodeNum*(quicklistNodeSize+listpackHeader) + entries
+	}
+	return List(key, members, length)
+}
+
+func SetEncoded(encoding, key string, members [][]byte, length int) int {
+	switch encoding {
+	case "intset":
+		width := 2
+		for _, member := range members {
+			if n, err := strconv.ParseInt(string(member), 10, 64); err == nil {
+				if w := intWidth(n); w > width {
+					width = w
+				}
+			}
+		}
+		return keySize(key) + alloc(intsetHeader+width*count(len(members), length))
+	case "listpack":
+		return keySize(key) + alloc(listpackHeader+extrapolate(listpackEntries(members), len(members), length))
+	case "hashtable":
+		elements := 0
+		for _, member := range members {
+			elements += dictEntrySize + alloc(sdsSize(len(member)))
+		}
+		num := count(len(members), length)
+		return keySize(key) + extrapolate(elements, len(members), length) + buckets(num)
+	}
+	return Set(key, members, length)
+}
+
+func HashEncoded(encoding, key string, memberValues [][]byte, length int) int {
+	sample := len(memberValues) / 2
+	switch encoding {
+	case "ziplist", "listpack":
+		return keySize(key) + alloc(listpackHeader+extrapolate(listpackEntries(memberValues), sample, length))
+	case "hashtable":
+		elements := 0
+		for i := 0; i+1 < len(memberValues); i += 2 {
+			elements += dictEntrySize + alloc(sdsSize(len(memberValues[i]))) + alloc(sdsSize(len(memberValues[i+1])))
+		}
+		num := count(sample, length)
+		return keySize(key) + extrapolate(elements, sample, length) + buckets(num)
+	}
+	return Hash(key, memberValues, length)
+}
+
+// ZsetEncoded members don't include scores, assume 9 bytes per score entry in listpack
+func ZsetEncoded(encoding, key string, members [][]byte, length int) int {
+	switch encoding {
+	case "ziplist", "listpack":
+		entries := listpackEntries(members) + 9*len(members)
+		return keySize(key) + alloc(listpackHeader+extrapolate(entries, len(members), length))
+	case "skiplist":
+		elements := 0
+		for _, member := range members {
+			elements += dictEntrySize + skiplistNodeSize + alloc(sdsSize(len(member)))
+		}
+		num := count(len(members), length)
+		return keySize(key) + extrapolate(elements, len(members), length) + buckets(num)
+	}
+	return Zset(key, members, length)
+}
+
+// extrapolate total from the sum of sampled elements
+func extrapolate(sum, sample, length int) int {
+	if length < 0 || sample == 0 {
+		return sum
+	}
+	return sum * length / sample
+}
+
+func count(sample, length int) int {
+	if length < 0 {
+		return sample
+	}
+	return length
+}
+
+// buckets of hash table, size is power of 2
+func buckets(num int) int {
+	if num == 0 {
+		return 0
+	}
+	return bucketSize << bits.Len(uint(num-1))
+}
+
+func listpackEntries(members [][]byte) int {
+	total := 0
+	for _, member := range members {
+		total += listpackEntry(member)
+	}
+	return total
+}
+
+// listpackEntry return bytes of encoding, data and back length of an element
+func listpackEntry(member []byte) int {
+	var entry int
+	if n, err := strconv.ParseInt(string(member), 10, 64); err == nil && len(member) < 20 {
+		switch {
+		case n >= 0 && n < 1<<7:
+			entry = 1
+		case n >= -1<<12 && n < 1<<12:
+			entry = 2
+		default:
+			entry = 1 + intWidth(n)
+		}
+	} else {
+		switch l := len(member); {
+		case l < 1<<6:
+			entry = 1 + l
+		case l < 1<<12:
+			entry = 2 + l
+		default:
+			entry = 5 + l
+		}
+	}
+	switch {
+	case entry < 1<<7:
+		return entry + 1
+	case entry < 1<<14:
+		return entry + 2
+	}
+	return entry + 5
+}
+
+func intWidth(n int64) int {
+	switch {
+	case n >= -1<<15 && n < 1<<15:
+		return 2
+	case n >= -1<<31 && n < 1<<31:
+		return 4
+	}
+	return 8
+}
+
+func sdsSize(length int) int {
+	switch {
+	case length < 1<<5:
+		return 1 + length + 1
+	case length < 1<<8:
+		return 3 + length + 1
+	case length < 1<<16:
+		return 5 + length + 1
+	}
+	return 9 + length + 1
+}
+
+// alloc round up to jemalloc size class
+func alloc(size int) int {
+	if size <= 8 {
+		return 8
+	}
+	if size <= 128 {
+		return (size + 15) &^ 15
+	}
+	step := 1 << (bits.Len(uint(size-1)) - 3)
+	return (size + step - 1) &^ (step - 1)
+}
@@ -0,0 +1,88 @@
+package size
+
+import (
+	"strings"
+	"testing"
+)
+
+func members(s ...string) [][]byte {
+	var bs [][]byte
+	for _, m := range s {
+		bs = append(bs, []byte(m))
+	}
+	return bs
+}
+
+// expected sizes follow the layout of redis 6/7 on 64-bit platform, the key "k" takes 57 bytes
+func TestEncoded(t *testing.T) {
+	x40 := strings.Repeat("x", 40)
+	for _, c := range []struct {
+		name string
+		got  int
+		want int
+	}{
+		{"string int", StringEncoded("int", "k", nil, 3), 57},
+		{"string embstr", StringEncoded("embstr", "k", nil, 5), 57 + 7},
+		{"string raw", StringEncoded("raw", "k", nil, 100), 57 + 112},
+		{"string unknown", StringEncoded("", "k", nil, 5), String("k", nil, 5)},
+
+		{"list listpack", ListEncoded("listpack", "k", members("a", "bb", "1"), -1), 57 + 16},
+		{"list ziplist", ListEncoded("ziplist", "k", members("a", "bb", "1"), -1), 57 + 16},
+		{"list listpack sampled", ListEncoded("listpack", "k", members("a", "bb", "1"), 30), 57 + 112},
+		{"list quicklist", ListEncoded("quicklist", "k", members("a", "bb", "1"), 3000), 57 + 40 + 2*(32+7) + 9000},
+		{"list unknown", ListEncoded("", "k", members("a"), -1), List("k", members("a"), -1)},
+
+		{"set intset", SetEncoded("intset", "k", members("1", "2", "70000"), -1), 57 + 32},
+		{"set intset sampled", SetEncoded("intset", "k", members("1", "2"), 100), 57 + 224},
+		{"set listpack", SetEncoded("listpack", "k", members("a", "bb"), -1), 57 + 16},
+		{"set hashtable", SetEncoded("hashtable", "k", members("a", x40), -1), 57 + (24 + 8) + (24 + 48) + 16},
+		{"set hashtable sampled", SetEncoded("hashtable", "k", members("a", "b"), 1000), 57 + 32000 + 8192},
+
+		{"hash listpack", HashEncoded("listpack", "k", members("f", "1", "name", "alice"), -1), 57 + 32},
+		{"hash hashtable", HashEncoded("hashtable", "k", members("f", "1", "name", "alice"), -1), 57 + 2*(24+8+8) + 16},
+		{"hash unknown", HashEncoded("", "k", members("f", "1"), -1), Hash("k", members("f", "1"), -1)},
+
+		{"zset listpack", ZsetEncoded("listpack", "k", members("a", "bb"), -1), 57 + 32},
+		{"zset skiplist", ZsetEncoded("skiplist", "k", members("a"), -1), 57 + (24 + 48 + 8) + 8},
+		{"zset unknown", ZsetEncoded("", "k", members("a"), -1), Zset("k", members("a"), -1)},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s: expected %d, got %d", c.name, c.want, c.got)
+		}
+	}
+}
+
+func TestListpackEntry(t *testing.T) {
+	for member, want := range map[string]int{
+		"127":                    2, // 7 bit uint
+		"128":                    3, // 13 bit int
+		"-4096":                  3,
+		"4096":                   4,  // 16 bit int
+		"12345678901234567890":   22, // overflow int64, stored as string
+		strings.Repeat("a", 63):  65,
+		strings.Repeat("a", 64):  67,  // 12 bit string length
+		strings.Repeat("a", 200): 204, // 2 bytes back length
+	} {
+		if got := listpackEntry([]byte(member)); got != want {
+			t.Errorf("listpackEntry(%q) expected %d, got %d", member, want, got)
+		}
+	}
+}
+
+func TestAllocAndBuckets(t *testing.T) {
+	for size, want := range map[int]int{0: 8, 8: 8, 9: 16, 128: 128, 129: 160, 1000: 1024} {
+		if got := alloc(size); got != want {
+			t.Errorf("alloc(%d) expected %d, got %d", size, want, got)
+		}
+	}
+	for length, want := range map[int]int{31: 33, 32: 36, 255: 259, 256: 262} {
+		if got := sdsSize(length); got != want {
+			t.Errorf("sdsSize(%d) expected %d, got %d", length, want, got)
+		}
+	}
+	for num, want := range map[int]int{0: 0, 1: 8, 2: 16, 3: 32, 4: 32, 5: 64} {
+		if got := buckets(num); got != want {
+			t.Errorf("buckets(%d) expected %d, got %d", num, want, got)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+// SnapshotInterval is the min interval between snapshots of an incomplete tree, the latest one is reused meanwhile
+var SnapshotInterval = time.Second
+
+// Snapshot is an immutable copy of KeyTypeTree, so it can be read without lock while the analyzer is still writing.
+// Trees of an incomplete snapshot share chunks with the live tree and are not finalized, so single child nodes
+// are not merged and small children are not folded until the analysis is complete
+type Snapshot struct {
+	Version  uint64
+	Time     time.Time
+	Complete bool      // false if the analysis is still running or interrupted
+	Cursors  []*Cursor // where sources stopped if the analysis is interrupted
+	trees    [6]*tree.Tree
+	allOnce  sync.Once
+	all      *tree.Tree // keys of all types, broken down by type, built by the first Tree(KeyTypeAll)
+
+	foldSize   int64
+	foldKeyNum int64
+	bigKeyNum  int
+	bigKeys    [6][]*BigKey // sorted by size desc
+	ttl        [6]*TTLStats
+}
+
+// Snapshot return the latest snapshot, a new one is taken if the tree changed and the latest one is older
+// than SnapshotInterval or the tree becomes complete
+func (k *KeyTypeTree) Snapshot() *Snapshot {
+	k.snapshotMu.Lock()
+	defer k.snapshotMu.Unlock()
+
+	k.rw.RLock()
+	if s := k.snapshot; s != nil && (s.Version == k.version || !k.complete && time.Since(s.Time) < SnapshotInterval) {
+		k.rw.RUnlock()
+		return s
+	}
+	s := &Snapshot{
+		Version:  k.version,
+		Time:     time.Now(),
+		Complete: k.complete && len(k.cursors) == 0,
+		Cursors:  append([]*Cursor(nil), k.cursors...),
+	}
+	// trees are frozen in time of chunk num, chunks are copied on write afterwards by whichever writes them
+	for i, t := range k.trees {
+		if t != nil {
+			s.trees[i] = t.Freeze()
+			s.bigKeys[i] = sortBigKeys(k.bigKeys[i].keys, k.bigKeyNum)
+			s.ttl[i] = k.ttl[i].merge(nil)
+		}
+	}
+	s.bigKeyNum, s.foldSize, s.foldKeyNum = k.bigKeyNum, k.foldSize, k.foldKeyNum
+	k.rw.RUnlock()
+
+	k.snapshot = s
+	return s
+}
+
+// Tree return the tree of key type for walking and querying, which must not be modified.
+// Nodes of the tree of KeyTypeAll are broken down by key type with Node.Sources
+func (s *Snapshot) Tree(keyT KeyType) *tree.Tree {
+	if keyT == KeyTypeAll {
+		s.allOnce.Do(s.combine)
+		return s.all
+	}
+	return s.trees[keyT]
+}
+
+// combine build the tree of all types from trees of every type
+func (s *Snapshot) combine() {
+	all, err := tree.Combine(KeyTypeAllStr, s.trees[KeyTypeString:]...)
+	errorJudge("combine trees of all types", err)
+	all.MergeSingleChildNode()
+	all.Fold(s.foldSize, s.foldKeyNum)
+	s.all = all
+}
+
+func (s *Snapshot) GetSize(keyPrefix string, keyT KeyType) int64 {
+	return s.Tree(keyT).GetSize(keyPrefix)
+}
+
+// GetKeyTypeStr return types having keys, led by KeyTypeAllStr if there are any
+func (s *Snapshot) GetKeyTypeStr() []string {
+	var typeStrs []string
+	for keyType := KeyTypeString; keyType <= KeyTypeZset; keyType++ {
+		if s.trees[keyType].GetKeyNum() > 0 {
+			typeStrs = append(typeStrs, KeyTypeToTypeStr[keyType])
+		}
+	}
+	if len(typeStrs) == 0 {
+		return nil
+	}
+	return append([]string{KeyTypeAllStr}, typeStrs...)
+}
+
+// Expand return children of key prefix in the tree of key type, or of all types by KeyTypeAll
+func (s *Snapshot) Expand(keyPrefix string, keyT KeyType) map[string]*tree.Node {
+	return s.Tree(keyT).Expand(keyPrefix)
+}
+
+// BigKeys return the biggest keys of key type sorted by size desc, or of all types by KeyTypeAll
+func (s *Snapshot) BigKeys(keyT KeyType) []*BigKey {
+	if keyT != KeyTypeAll {
+		return s.bigKeys[keyT]
+	}
+	var keys []*BigKey
+	for _, typeKeys := range s.bigKeys {
+		keys = append(keys, typeKeys...)
+	}
+	return sortBigKeys(keys, s.bigKeyNum)
+}
+
+// TTLStats return TTL stats of key type or of all types by KeyTypeAll, nil if TTL is not collected
+func (s *Snapshot) TTLStats(keyT KeyType) *TTLStats {
+	if keyT != KeyTypeAll {
+		return s.ttl[keyT]
+	}
+	var stats *TTLStats
+	for _, typeStats := range s.ttl {
+		if typeStats != nil {
+			stats = stats.merge(typeStats)
+		}
+	}
+	return stats
+}
+
+// GetSampling return nil if trees are built by full scan
+func (s *Snapshot) GetSampling() *tree.Sampling {
+	return s.trees[KeyTypeString].GetSampling()
+}
+
+func (s *Snapshot) Print() {
+	s.Fprint(os.Stdout, escape.Auto)
+}
+
+// Fprint write summary and trees to w, key segments are escaped by mode
+func (s *Snapshot) Fprint(w io.Writer, mode escape.Mode) {
+	fmt.Fprintln(w, "Summary:")
+	sampling := s.GetSampling()
+	if sampling != nil {
+		fmt.Fprintf(w, "Sampled %d of %d keys, z:%.2f\n", sampling.SampleNum, sampling.Population, sampling.Z)
+	}
+	for i, t := range s.trees {
+		if t == nil {
+			continue
+		}
+		fmt.Fprintf(w, "Type:%s KeyNum:%d TotalSize:%d\n", KeyTypeToTypeStr[i], t.GetKeyNum(), t.GetTotalSize())
+		if sampling != nil {
+			e := t.Estimate()
+			fmt.Fprintf(w, "  EstKeyNum:%.0f [%.0f, %.0f] EstTotalSize:%.0f [%.0f, %.0f]\n",
+				e.KeyNum, e.KeyNumLow, e.KeyNumHigh, e.Size, e.SizeLow, e.SizeHigh)
+		}
+	}
+	if len(s.Cursors) > 0 {
+		fmt.Fprintf(w, "Incomplete: analysis is interrupted at %v\n", s.Cursors)
+	} else if !s.Complete {
+		fmt.Fprintln(w, "Incomplete: analysis is still running")
+	}
+	fmt.Fprintln(w, "Detail:")
+	for _, t := range s.trees {
+		if t == nil {
+			continue
+		}
+		t.Fprint(w, mode)
+		fmt.Fprintln(w)
+	}
+}
+
+// snapshotFile is the saved form of Snapshot, trees are keyed by type
+type snapshotFile struct {
+	Version    uint64                   `json:"version"`
+	Time       time.Time                `json:"time"`
+	Complete   bool                     `json:"complete"`
+	Cursors    []*Cursor                `json:"cursors,omitempty"`
+	Trees      map[string]*tree.Dump    `json:"trees"`
+	FoldSize   int64                    `json:"fold_size,omitempty"`
+	FoldKeyNum int64                    `json:"fold_key_num,omitempty"`
+	BigKeyNum  int                      `json:"big_key_num"`
+	BigKeys    map[string][]*bigKeyDump `json:"big_keys"`
+	TTL        map[string]*TTLStats     `json:"ttl,omitempty"`
+}
+
+// bigKeyDump is the saved form of BigKey, key of invalid utf-8 is kept in KeyBytes like tree.DumpNode
+type bigKeyDump struct {
+	BigKey
+	Key      string `json:"key,omitempty"`
+	KeyBytes []byte `json:"key_bytes,omitempty"`
+}
+
+// Save write snapshot as json, which can be loaded by LoadSnapshot
+func (s *Snapshot) Save(w io.Writer) error {
+	f := &snapshotFile{Version: s.Version, Time: s.Time, Complete: s.Complete, Cursors: s.Cursors, Trees: make(map[string]*tree.Dump),
+		FoldSize: s.foldSize, FoldKeyNum: s.foldKeyNum, BigKeyNum: s.bigKeyNum, BigKeys: make(map[string][]*bigKeyDump),
+		TTL: make(map[string]*TTLStats)}
+	for i, t := range s.trees {
+		if t == nil {
+			continue
+		}
+		typeStr := KeyTypeToTypeStr[KeyType(i)]
+		f.Trees[typeStr] = t.Dump()
+		for _, key := range s.bigKeys[i] {
+			d := &bigKeyDump{BigKey: *key, Key: key.Key}
+			if !utf8.ValidString(key.Key) {
+				d.Key, d.KeyBytes = "", []byte(key.Key)
+			}
+			f.BigKeys[typeStr] = append(f.BigKeys[typeStr], d)
+		}
+		if s.ttl[i] != nil {
+			f.TTL[typeStr] = s.ttl[i]
+		}
+	}
+	return json.NewEncoder(w).Encode(f)
+}
+
+func LoadSnapshot(r io.Reader) (*Snapshot, error) {
+	f := &snapshotFile{}
+	if err := json.NewDecoder(r).Decode(f); err != nil {
+		return nil, err
+	}
+	s := &Snapshot{Version: f.Version, Time: f.Time, Complete: f.Complete, Cursors: f.Cursors,
+		foldSize: f.FoldSize, foldKeyNum: f.FoldKeyNum, bigKeyNum: f.BigKeyNum}
+	for typeStr, d := range f.Trees {
+		keyT, ok := KeyTypeStrToType[typeStr]
+		if !ok {
+			return nil, fmt.Errorf("unknown key type %q", typeStr)
+		}
+		s.trees[keyT] = tree.Load(d)
+		for _, d := range f.BigKeys[typeStr] {
+			key := d.BigKey
+			key.Key = d.Key
+			if d.KeyBytes != nil {
+				key.Key = string(d.KeyBytes)
+			}
+			s.bigKeys[keyT] = append(s.bigKeys[keyT], &key)
+		}
+		s.ttl[keyT] = f.TTL[typeStr]
+	}
+	for i := KeyTypeString; i <= KeyTypeZset; i++ {
+		if s.trees[i] == nil {
+			return nil, fmt.Errorf("no tree of key type %q", KeyTypeToTypeStr[i])
+		}
+	}
+	return s, nil
+}
+
+// Merge return a new snapshot with keys of both snapshots, nodes keep what every source contributes.
+// It keeps the larger big key num and fold thresholds of both, trees are limited by the shallower max depth
+func (s *Snapshot) Merge(o *Snapshot) (*Snapshot, error) {
+	merged := &Snapshot{Time: time.Now(), Complete: s.Complete && o.Complete, bigKeyNum: s.bigKeyNum,
+		foldSize: s.foldSize, foldKeyNum: s.foldKeyNum, Cursors: append(append([]*Cursor(nil), s.Cursors...), o.Cursors...)}
+	if o.bigKeyNum > merged.bigKeyNum {
+		merged.bigKeyNum = o.bigKeyNum
+	}
+	if o.foldSize > merged.foldSize {
+		merged.foldSize = o.foldSize
+	}
+	if o.foldKeyNum > merged.foldKeyNum {
+		merged.foldKeyNum = o.foldKeyNum
+	}
+	for i, t := range s.trees {
+		if t != nil {
+			tr, err := tree.Merge(t, o.trees[i])
+			if err != nil {
+				return nil, err
+			}
+			merged.trees[i] = tr
+			merged.bigKeys[i] = sortBigKeys(append(append([]*BigKey(nil), s.bigKeys[i]...), o.bigKeys[i]...), merged.bigKeyNum)
+			merged.ttl[i] = s.ttl[i].merge(o.ttl[i])
+		}
+	}
+	finalize(merged.trees, merged.foldSize, merged.foldKeyNum)
+	return merged, nil
+}
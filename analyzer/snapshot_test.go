@@ -0,0 +1,231 @@
+package analyzer
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+func TestSnapshot(t *testing.T) {
+	defer func(interval time.Duration) { SnapshotInterval = interval }(SnapshotInterval)
+	SnapshotInterval = 0
+	k := NewKeyTypeTree(tree.ByteRules([]byte(":")))
+	k.AddKey(&KeyInfo{Key: "user:1", KeyT: KeyTypeString, Size: 10})
+	k.AddKey(&KeyInfo{Key: "user:2", KeyT: KeyTypeString, Size: 20})
+	s := k.Snapshot()
+	k.AddKey(&KeyInfo{Key: "user:3", KeyT: KeyTypeString, Size: 30})
+	if size := s.GetSize("user:", KeyTypeString); size != 30 {
+		t.Errorf("Expected snapshot size 30, got %d", size)
+	}
+	if size := k.GetSize("user:", KeyTypeString); size != 60 {
+		t.Errorf("Expected size 60, got %d", size)
+	}
+	if s.Complete || k.Snapshot() == s {
+		t.Errorf("Expected a new incomplete snapshot")
+	}
+	// only complete snapshots are folded, incomplete ones share chunks with the live tree
+	k.SetFold(0, 100)
+	if children := k.Snapshot().Expand("user:", KeyTypeString); len(children) != 3 {
+		t.Errorf("Expected 3 children of incomplete snapshot, got %v", children)
+	}
+	k.SetFold(0, 0)
+
+	// writes and reads at the same time, run with -race
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			k.AddKey(&KeyInfo{Key: fmt.Sprintf("order:%d:%d", i%10, i), KeyT: KeyTypeHash, Size: 1})
+		}
+		k.Finish()
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s := k.Snapshot()
+			if got := s.GetSize("order:", KeyTypeHash); got < 0 || got > 1000 {
+				t.Errorf("Unexpected size %d", got)
+			}
+		}
+	}()
+	wg.Wait()
+	s = k.Snapshot()
+	if !s.Complete || s.GetSize("order:", KeyTypeHash) != 1000 || k.Snapshot() != s {
+		t.Errorf("Expected complete snapshot")
+	}
+}
+
+func TestSnapshotSaveAndMerge(t *testing.T) {
+	var snapshots []*Snapshot
+	for i, source := range []string{"10.0.0.1:6379", "10.0.0.2:6379"} {
+		k := NewKeyTypeTree(tree.ByteRules([]byte(":")))
+		k.SetSource(source)
+		k.AddKey(&KeyInfo{Key: fmt.Sprintf("user:%d", i), KeyT: KeyTypeString, Size: 10})
+		k.AddKey(&KeyInfo{Key: "order:1", KeyT: KeyTypeHash, Size: 1})
+		k.AddKey(&KeyInfo{Key: "pb:\x08\x96\x01", KeyT: KeyTypeHash, Size: 3, TTL: -1}) // not utf-8
+		k.Finish()
+		buf := &bytes.Buffer{}
+		if err := k.Snapshot().Save(buf); err != nil {
+			t.Fatal(err)
+		}
+		s, err := LoadSnapshot(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshots = append(snapshots, s)
+	}
+	merged, err := snapshots[0].Merge(snapshots[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !merged.Complete || merged.GetSize("user:", KeyTypeString) != 20 || merged.GetSize("order:1", KeyTypeHash) != 2 ||
+		merged.GetSize("pb:\x08\x96\x01", KeyTypeHash) != 6 {
+		t.Errorf("Unexpected merged snapshot")
+	}
+	sources := merged.Expand("", KeyTypeString)["user:"].Sources()
+	if len(sources) != 2 || sources[0].Source != "10.0.0.1:6379" || sources[1].Size != 10 {
+		t.Errorf("Unexpected sources %v", sources)
+	}
+	bigKeys := merged.BigKeys(KeyTypeHash)
+	if len(bigKeys) != 4 || bigKeys[0].Key != "pb:\x08\x96\x01" || bigKeys[0].TTL != -1 {
+		t.Errorf("Unexpected big keys %+v", bigKeys)
+	}
+	if stats := merged.TTLStats(KeyTypeAll); stats == nil || stats.Buckets[0].KeyNum != 2 || stats.Buckets[0].Size != 6 {
+		t.Errorf("Unexpected TTL stats %+v", stats)
+	}
+}
+
+func TestMergeFoldAndMaxDepth(t *testing.T) {
+	var trees []*KeyTypeTree
+	var snapshots []*Snapshot
+	for i, source := range []string{"10.0.0.1:6379", "10.0.0.2:6379"} {
+		k := NewKeyTypeTree(tree.ByteRules([]byte(":")))
+		k.SetSource(source)
+		k.SetMaxDepth(2)
+		if i == 1 { // small children of the other source are folded after merge
+			k.SetFold(0, 2)
+		}
+		for j := 0; j < 3; j++ {
+			k.AddKey(&KeyInfo{Key: fmt.Sprintf("user:%d:%d:name", i, j), KeyT: KeyTypeString, Size: 10})
+		}
+		k.AddKey(&KeyInfo{Key: fmt.Sprintf("job%d", i), KeyT: KeyTypeString, Size: 1})
+		k.Finish()
+		buf := &bytes.Buffer{}
+		if err := k.Snapshot().Save(buf); err != nil {
+			t.Fatal(err)
+		}
+		s, err := LoadSnapshot(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trees = append(trees, k)
+		snapshots = append(snapshots, s)
+	}
+	merged, err := snapshots[0].Merge(snapshots[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// job0 is folded with job1 folded by the second source, user:0: and user:1: are kept at max depth
+	children := merged.Expand("", KeyTypeString)
+	if other := children[tree.OtherSegment]; len(children) != 2 || other == nil || other.KeyNum != 2 {
+		t.Errorf("Expected user: and %s of 2 keys, got %v", tree.OtherSegment, children)
+	}
+	if children := merged.Expand("user:0:", KeyTypeString); len(children) != 0 {
+		t.Errorf("Expected no children deeper than max depth, got %v", children)
+	}
+
+	// merge both ways at the same time without deadlock
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	for i := range trees {
+		go func(k, o *KeyTypeTree) {
+			defer wg.Done()
+			if err := k.Merge(o); err != nil {
+				t.Error(err)
+			}
+		}(trees[i], trees[1-i])
+	}
+	wg.Wait()
+}
+
+func TestBigKeysAndTTL(t *testing.T) {
+	k := NewKeyTypeTree(tree.ByteRules([]byte(":")))
+	k.SetBigKeyNum(2)
+	k.AddKey(&KeyInfo{Key: "a", KeyT: KeyTypeString, Size: 5, TTL: -1})
+	k.AddKey(&KeyInfo{Key: "b", KeyT: KeyTypeString, Size: 1, TTL: 1000})
+	k.AddKey(&KeyInfo{Key: "c", KeyT: KeyTypeString, Size: 3, TTL: 2 * 24 * 3600 * 1000})
+	k.AddKey(&KeyInfo{Key: "d", KeyT: KeyTypeList, Size: 4})
+	k.Finish()
+	s := k.Snapshot()
+	var keys []string
+	for _, key := range s.BigKeys(KeyTypeAll) {
+		keys = append(keys, key.Key)
+	}
+	if want := []string{"a", "d"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Expected big keys %q, got %q", want, keys)
+	}
+	if s.TTLStats(KeyTypeList) != nil {
+		t.Errorf("Expected no TTL stats of list")
+	}
+	var got [][2]int64
+	for _, bucket := range s.TTLStats(KeyTypeAll).Buckets {
+		got = append(got, [2]int64{bucket.KeyNum, bucket.Size})
+	}
+	if want := [][2]int64{{1, 5}, {1, 1}, {0, 0}, {1, 3}, {0, 0}, {0, 0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected TTL buckets %v, got %v", want, got)
+	}
+
+	// keys smaller than kept ones are not allocated
+	small := &KeyInfo{Key: "b", KeyT: KeyTypeString, Size: 1}
+	if allocs := testing.AllocsPerRun(100, func() { k.AddKey(small) }); allocs != 0 {
+		t.Errorf("Expected no allocation adding a small key, got %v", allocs)
+	}
+	k.SetBigKeyNum(0)
+	if k.bigKeyNum != DefaultBigKeyNum {
+		t.Errorf("Expected %d big keys by 0, got %d", DefaultBigKeyNum, k.bigKeyNum)
+	}
+	k.SetBigKeyNum(-1)
+	if keys := k.Snapshot().BigKeys(KeyTypeAll); len(keys) != 0 {
+		t.Errorf("Expected no big keys by negative, got %v", keys)
+	}
+}
+
+func TestAllTypes(t *testing.T) {
+	k := NewKeyTypeTree(tree.ByteRules([]byte(":")))
+	k.AddKey(&KeyInfo{Key: "user:1", KeyT: KeyTypeString, Size: 10})
+	k.AddKey(&KeyInfo{Key: "user:2", KeyT: KeyTypeHash, Size: 20})
+	k.AddKey(&KeyInfo{Key: "user:3", KeyT: KeyTypeHash, Size: 5})
+	k.AddKey(&KeyInfo{Key: "order:1", KeyT: KeyTypeZset, Size: 7})
+	k.Finish()
+	s := k.Snapshot()
+	if got, want := s.GetKeyTypeStr(), []string{KeyTypeAllStr, "string", "hash", "zset"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if s.all != nil {
+		t.Errorf("Expected tree of all types not built before it is read")
+	}
+	// built once by the first of concurrent readers, run with -race
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			s.Tree(KeyTypeAll)
+		}()
+	}
+	wg.Wait()
+	user := s.Expand("", KeyTypeAll)["user:"]
+	want := []tree.SourceStat{{Source: "string", KeyNum: 1, Size: 10}, {Source: "hash", KeyNum: 2, Size: 25}}
+	if user == nil || user.Size != 35 || !reflect.DeepEqual(user.Sources(), want) {
+		t.Errorf("Expected user: of size 35 and types %v, got %+v", want, user)
+	}
+	if size := s.GetSize("user:", KeyTypeHash); size != 25 {
+		t.Errorf("Expected size 25, got %d", size)
+	}
+}
@@ -0,0 +1,150 @@
+package tree
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+const benchKeyNum = 200000
+
+// benchKeys return keys with shared prefixes and unique ids like real key spaces
+func benchKeys(num int) []string {
+	keys := make([]string, 0, num)
+	for i := 0; len(keys) < num; i++ {
+		keys = append(keys,
+			fmt.Sprintf("user:%d:profile", i),
+			fmt.Sprintf("user:%d:session:%x", i, i*2654435761),
+			fmt.Sprintf("order:2023:%d:items", i),
+			fmt.Sprintf("cache:item:%08x", i*40503),
+		)
+	}
+	return keys[:num]
+}
+
+// heapBytesPerKey build a tree of keys and return the retained heap bytes per key
+func heapBytesPerKey(keys []string, build func([]string) interface{}) float64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	t := build(keys)
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(t)
+	return float64(after.HeapAlloc-before.HeapAlloc) / float64(len(keys))
+}
+
+// copyKeys make keys not share memory with the generated ones, as keys read from redis
+func copyKeys(keys []string) []string {
+	copied := make([]string, len(keys))
+	for i, key := range keys {
+		copied[i] = string([]byte(key))
+	}
+	return copied
+}
+
+func BenchmarkMemoryCompact(b *testing.B) {
+	keys := benchKeys(benchKeyNum)
+	for i := 0; i < b.N; i++ {
+		b.ReportMetric(heapBytesPerKey(copyKeys(keys), func(keys []string) interface{} {
+			t := New("", []byte{':'})
+			for _, key := range keys {
+				t.AddKey(key, 100)
+			}
+			return t
+		}), "heap-bytes/key")
+	}
+}
+
+// BenchmarkMemoryMapTree measure the previous tree which has a map and a segment string per node, as baseline
+func BenchmarkMemoryMapTree(b *testing.B) {
+	keys := benchKeys(benchKeyNum)
+	for i := 0; i < b.N; i++ {
+		b.ReportMetric(heapBytesPerKey(copyKeys(keys), func(keys []string) interface{} {
+			t := &mapNode{}
+			for _, key := range keys {
+				t.addKey(key, 100, ':')
+			}
+			return t
+		}), "heap-bytes/key")
+	}
+}
+
+func BenchmarkAddKey(b *testing.B) {
+	keys := benchKeys(benchKeyNum)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		t := New("", []byte{':'})
+		for _, key := range keys {
+			t.AddKey(key, 100)
+		}
+	}
+}
+
+func BenchmarkGetSize(b *testing.B) {
+	keys := benchKeys(benchKeyNum)
+	t := New("", []byte{':'})
+	for _, key := range keys {
+		t.AddKey(key, 100)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		t.GetSize(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkExpand(b *testing.B) {
+	keys := benchKeys(benchKeyNum)
+	t := New("", []byte{':'})
+	for _, key := range keys {
+		t.AddKey(key, 100)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		t.Expand("order:2023:")
+	}
+}
+
+func BenchmarkFreeze(b *testing.B) {
+	t := New("", []byte{':'})
+	for _, key := range benchKeys(benchKeyNum) {
+		t.AddKey(key, 100)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		t.Freeze()
+		t.AddKey("user:0:profile", int64(i)) // the first write copies the chunk of each node on path
+	}
+}
+
+// mapNode is the previous tree node, only for memory comparison
+type mapNode struct {
+	Segment string
+	KeyNum  int64
+	Size    int64
+	Child   map[string]*mapNode
+}
+
+func (n *mapNode) addKey(key string, size int64, separator byte) {
+	left := 0
+	for right := 0; right < len(key); right++ {
+		if key[right] != separator && right != len(key)-1 {
+			continue
+		}
+		segment := key[left : right+1]
+		if n.Child == nil {
+			n.Child = make(map[string]*mapNode)
+		}
+		child, ok := n.Child[segment]
+		if !ok {
+			child = &mapNode{Segment: segment}
+			n.Child[segment] = child
+		}
+		n.Size += size
+		n.KeyNum++
+		n = child
+		left = right + 1
+	}
+	n.Size += size
+	n.KeyNum++
+}
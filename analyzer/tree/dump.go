@@ -0,0 +1,114 @@
+package tree
+
+import "unicode/utf8"
+
+// Dump is the serializable form of a tree, Load restores the same tree from it
+type Dump struct {
+	Name     string    `json:"name"`
+	Rules    []Rule    `json:"rules"`
+	MaxDepth int       `json:"max_depth,omitempty"`
+	Source   string    `json:"source,omitempty"`
+	Sources  []string  `json:"sources,omitempty"` // only for merged tree
+	Sampling *Sampling `json:"sampling,omitempty"`
+	Root     *DumpNode `json:"root"`
+}
+
+// DumpNode is the serializable form of a node, segment of invalid utf-8 is kept in SegmentBytes,
+// which is encoded as base64 by json, instead of being replaced by U+FFFD
+type DumpNode struct {
+	Segment      string      `json:"segment,omitempty"`
+	SegmentBytes []byte      `json:"segment_bytes,omitempty"`
+	KeyNum       int64       `json:"key_num"`
+	Size         int64       `json:"size"`
+	Sources      []DumpStat  `json:"sources,omitempty"` // by index of Dump.Sources
+	Squares      float64     `json:"squares,omitempty"` // sum of squared size of keys owned by node aggregating keys
+	Strata       []DumpStat  `json:"strata,omitempty"`  // by index of Sampling.Strata
+	Children     []*DumpNode `json:"children,omitempty"`
+}
+
+// DumpStat is the part of a dumped node contributed by a source or a stratum, only the latter has squares
+type DumpStat struct {
+	KeyNum  int64   `json:"key_num"`
+	Size    int64   `json:"size"`
+	Squares float64 `json:"squares,omitempty"`
+}
+
+func (t *Tree) Dump() *Dump {
+	d := &Dump{
+		Name:     t.segmentString(rootID),
+		Rules:    t.rules(),
+		MaxDepth: t.maxDepth,
+		Source:   t.source,
+		Sampling: t.sampling,
+		Root:     t.dumpNode(rootID),
+	}
+	if t.attribution != nil {
+		d.Sources = append([]string(nil), t.sources...)
+	}
+	return d
+}
+
+func (t *Tree) dumpNode(id uint32) *DumpNode {
+	n := t.node(id)
+	d := &DumpNode{KeyNum: n.keyNum, Size: n.size}
+	if segment := t.segment(id); utf8.Valid(segment) {
+		d.Segment = string(segment)
+	} else {
+		d.SegmentBytes = append([]byte(nil), segment...)
+	}
+	for _, stat := range t.attribution[id] {
+		d.Sources = append(d.Sources, DumpStat{KeyNum: stat.keyNum, Size: stat.size})
+	}
+	d.Squares = t.squares[id]
+	for _, stat := range t.strata[id] {
+		d.Strata = append(d.Strata, DumpStat{KeyNum: stat.keyNum, Size: stat.size, Squares: stat.squares})
+	}
+	for _, child := range t.sortedChildren(id) {
+		d.Children = append(d.Children, t.dumpNode(child))
+	}
+	return d
+}
+
+// Load restore tree from dump
+func Load(d *Dump) *Tree {
+	t := NewWithRules(d.Name, d.Rules)
+	t.maxDepth = d.MaxDepth
+	t.source = d.Source
+	t.sampling = d.Sampling
+	if d.Sources != nil {
+		t.sources = append([]string(nil), d.Sources...)
+		t.attribution = make(map[uint32][]sourceStat)
+	}
+	if d.Sampling != nil && len(d.Sampling.Strata) > 0 {
+		t.strata = make(map[uint32][]stratumStat)
+	}
+	if d.Root != nil {
+		t.loadNode(rootID, d.Root)
+	}
+	return t
+}
+
+func (t *Tree) loadNode(id uint32, d *DumpNode) {
+	n := t.mut(id)
+	n.keyNum = d.KeyNum
+	n.size = d.Size
+	for i, stat := range d.Sources {
+		t.attribute(id, i, stat.Size, stat.KeyNum)
+	}
+	if d.Squares != 0 {
+		if t.squares == nil {
+			t.squares = make(map[uint32]float64)
+		}
+		t.squares[id] = d.Squares
+	}
+	for k, stat := range d.Strata {
+		t.stratify(id, k, stratumStat{keyNum: stat.KeyNum, size: stat.Size, squares: stat.Squares})
+	}
+	for _, child := range d.Children {
+		segment := child.Segment
+		if child.SegmentBytes != nil {
+			segment = string(child.SegmentBytes)
+		}
+		t.loadNode(t.newNode(id, segment), child)
+	}
+}
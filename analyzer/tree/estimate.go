@@ -0,0 +1,203 @@
+package tree
+
+import (
+	"math"
+)
+
+// Sampling describe a tree built from a simple random sample of the key space
+type Sampling struct {
+	Population int64       // total key num of the key space, e.g. DBSIZE
+	SampleNum  int64       // key num sampled, including keys dropped by type or filter
+	Z          float64     // standard normal quantile of the confidence level
+	Strata     []*Sampling `json:"strata,omitempty"` // samplings of merged trees, which are estimated separately
+}
+
+// stratumStat is the part of a node from a stratum, squares is the sum of squared key size
+type stratumStat struct {
+	keyNum  int64
+	size    int64
+	squares float64
+}
+
+// stratify add stat to the stratum k of node, only if the tree is merged from sampled trees
+func (t *Tree) stratify(id uint32, k int, stat stratumStat) {
+	if t.strata == nil {
+		return
+	}
+	stats := t.strata[id]
+	for len(stats) <= k {
+		stats = append(stats, stratumStat{})
+	}
+	stats[k].keyNum += stat.keyNum
+	stats[k].size += stat.size
+	stats[k].squares += stat.squares
+	t.strata[id] = stats
+}
+
+// ownStrata return stats of strata of node which don't belong to children, a tree without strata is a single one
+func (t *Tree) ownStrata(id uint32) []stratumStat {
+	if t.strata == nil {
+		size, keyNum := t.own(id)
+		return []stratumStat{{keyNum: keyNum, size: size, squares: t.ownSquares(id)}}
+	}
+	own := append([]stratumStat(nil), t.strata[id]...)
+	for child := t.node(id).firstChild; child != rootID; child = t.node(child).nextSibling {
+		for k, stat := range t.strata[child] {
+			own[k].keyNum -= stat.keyNum
+			own[k].size -= stat.size
+			own[k].squares -= stat.squares
+		}
+	}
+	return own
+}
+
+// stratumSamplings return samplings of strata of tree, a fully scanned tree is a stratum sampling all its keys
+// with zero Z
+func (t *Tree) stratumSamplings() []*Sampling {
+	switch {
+	case t.sampling == nil:
+		return []*Sampling{{Population: t.GetKeyNum(), SampleNum: t.GetKeyNum()}}
+	case len(t.sampling.Strata) > 0:
+		return t.sampling.Strata
+	}
+	return []*Sampling{t.sampling}
+}
+
+// NewSampling return sampling with z computed from confidence level, e.g. 0.95
+func NewSampling(population, sampleNum int64, confidence float64) *Sampling {
+	if confidence <= 0 || confidence >= 1 {
+		confidence = 0.95
+	}
+	return &Sampling{
+		Population: population,
+		SampleNum:  sampleNum,
+		Z:          math.Sqrt2 * math.Erfinv(confidence),
+	}
+}
+
+// Estimate extrapolated totals of a node with confidence interval
+type Estimate struct {
+	KeyNum     float64 `json:"key_num"`
+	KeyNumLow  float64 `json:"key_num_low"`
+	KeyNumHigh float64 `json:"key_num_high"`
+	Size       float64 `json:"size"`
+	SizeLow    float64 `json:"size_low"`
+	SizeHigh   float64 `json:"size_high"`
+}
+
+// Estimate extrapolate KeyNum and Size of node to the whole key space.
+// Every sampled key is an observation y, which is its size (or 1 for KeyNum) if it is under node, otherwise 0,
+// the total is Population * mean(y) with normal approximation interval and finite population correction
+func (s *Sampling) Estimate(n *Node) *Estimate {
+	if s == nil || s.SampleNum == 0 {
+		return &Estimate{
+			KeyNum: float64(n.KeyNum), KeyNumLow: float64(n.KeyNum), KeyNumHigh: float64(n.KeyNum),
+			Size: float64(n.Size), SizeLow: float64(n.Size), SizeHigh: float64(n.Size),
+		}
+	}
+	if len(s.Strata) > 0 && n.tree.strata != nil {
+		return s.estimateStrata(n)
+	}
+	e := &Estimate{}
+	total, variance := s.total(float64(n.KeyNum), float64(n.KeyNum))
+	e.KeyNum, e.KeyNumLow, e.KeyNumHigh = s.interval(total, variance, float64(n.KeyNum))
+	total, variance = s.total(float64(n.Size), n.tree.sizeSquareSum(n.id))
+	e.Size, e.SizeLow, e.SizeHigh = s.interval(total, variance, float64(n.Size))
+	return e
+}
+
+// estimateStrata sum totals and variances estimated by every stratum of merged trees, so that trees sampled
+// at different fractions are extrapolated by their own fractions
+func (s *Sampling) estimateStrata(n *Node) *Estimate {
+	var keyNum, keyNumVariance, size, sizeVariance float64
+	stats := n.tree.strata[n.id]
+	for k, stratum := range s.Strata {
+		if k >= len(stats) || stratum.SampleNum == 0 {
+			continue
+		}
+		total, variance := stratum.total(float64(stats[k].keyNum), float64(stats[k].keyNum))
+		keyNum, keyNumVariance = keyNum+total, keyNumVariance+variance
+		total, variance = stratum.total(float64(stats[k].size), stats[k].squares)
+		size, sizeVariance = size+total, sizeVariance+variance
+	}
+	e := &Estimate{}
+	e.KeyNum, e.KeyNumLow, e.KeyNumHigh = s.interval(keyNum, keyNumVariance, float64(n.KeyNum))
+	e.Size, e.SizeLow, e.SizeHigh = s.interval(size, sizeVariance, float64(n.Size))
+	return e
+}
+
+// total return estimated total and its variance from sum and square sum of observations,
+// with finite population correction
+func (s *Sampling) total(sum, squareSum float64) (float64, float64) {
+	num := float64(s.SampleNum)
+	population := float64(s.Population)
+	mean := sum / num
+	total := population * mean
+	if s.SampleNum < 2 || s.Population <= s.SampleNum {
+		return total, 0
+	}
+	variance := (squareSum - num*mean*mean) / (num - 1)
+	if variance < 0 {
+		variance = 0
+	}
+	return total, population * population * variance / num * (population - num) / (population - 1)
+}
+
+// interval return total and its bounds by variance of the estimator, sum of the sampled keys is the lower bound
+func (s *Sampling) interval(total, variance, sum float64) (float64, float64, float64) {
+	if variance == 0 {
+		return total, total, total
+	}
+	margin := s.Z * math.Sqrt(variance)
+	low := total - margin
+	if low < sum { // the sampled keys exist for sure
+		low = sum
+	}
+	return total, low, total + margin
+}
+
+// sizeSquareSum return the sum of squared key size under node
+func (t *Tree) sizeSquareSum(id uint32) float64 {
+	sum := t.ownSquares(id)
+	for child := t.node(id).firstChild; child != rootID; child = t.node(child).nextSibling {
+		sum += t.sizeSquareSum(child)
+	}
+	return sum
+}
+
+// own return size and key num of node which don't belong to children
+func (t *Tree) own(id uint32) (int64, int64) {
+	n := t.node(id)
+	size, keyNum := n.size, n.keyNum
+	for child := n.firstChild; child != rootID; child = t.node(child).nextSibling {
+		size -= t.node(child).size
+		keyNum -= t.node(child).keyNum
+	}
+	return size, keyNum
+}
+
+// ownSquares return the sum of squared size of keys owned by node, a node without tracked squares is a single key
+func (t *Tree) ownSquares(id uint32) float64 {
+	if squares, ok := t.squares[id]; ok {
+		return squares
+	}
+	size, keyNum := t.own(id)
+	if keyNum <= 0 {
+		return 0
+	}
+	return float64(size) * float64(size)
+}
+
+// addSquares add squared size of keyNum keys aggregated into node by max depth, fold or merge, it must be called
+// before they are added to node. Squares are tracked only if node will own more than one key
+func (t *Tree) addSquares(id uint32, squares float64, keyNum int64) {
+	if _, ok := t.squares[id]; !ok {
+		if _, own := t.own(id); own+keyNum <= 1 {
+			return
+		}
+	}
+	if t.squares == nil {
+		t.squares = make(map[uint32]float64)
+	}
+	t.squares[id] = t.ownSquares(id) + squares
+}
@@ -0,0 +1,294 @@
+package tree
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// SourceStat is the part of a node contributed by a source, e.g. an instance or a shard
+type SourceStat struct {
+	Source string `json:"source"`
+	KeyNum int64  `json:"key_num"`
+	Size   int64  `json:"size"`
+}
+
+// sourceStat is SourceStat indexed by position in Tree.sources
+type sourceStat struct {
+	keyNum int64
+	size   int64
+}
+
+// SetSource name the source of keys added to the tree
+func (t *Tree) SetSource(source string) {
+	t.source = source
+}
+
+// Sources return names of sources contributing to the tree
+func (t *Tree) Sources() []string {
+	if t.attribution == nil {
+		return []string{t.source}
+	}
+	return append([]string(nil), t.sources...)
+}
+
+// sourceIndex return the index of source, it is appended if not exists
+func (t *Tree) sourceIndex(source string) int {
+	for i, s := range t.sources {
+		if s == source {
+			return i
+		}
+	}
+	t.sources = append(t.sources, source)
+	return len(t.sources) - 1
+}
+
+// attribute add size and key num of node to its source, only if the tree is merged from sources
+func (t *Tree) attribute(id uint32, src int, size, keyNum int64) {
+	if t.attribution == nil {
+		return
+	}
+	stats := t.attribution[id]
+	for len(stats) <= src {
+		stats = append(stats, sourceStat{})
+	}
+	stats[src].size += size
+	stats[src].keyNum += keyNum
+	t.attribution[id] = stats
+}
+
+// Sources break node down by sources, the tree itself is the only source if it isn't merged
+func (n *Node) Sources() []SourceStat {
+	t := n.tree
+	if t.attribution == nil {
+		return []SourceStat{{Source: t.source, KeyNum: n.KeyNum, Size: n.Size}}
+	}
+	var stats []SourceStat
+	for i, stat := range t.attribution[n.id] {
+		if stat.keyNum != 0 || stat.size != 0 {
+			stats = append(stats, SourceStat{Source: t.sources[i], KeyNum: stat.keyNum, Size: stat.size})
+		}
+	}
+	return stats
+}
+
+func (t *Tree) rules() []Rule {
+	rules := make([]Rule, 0, len(t.splitters))
+	for _, s := range t.splitters {
+		rules = append(rules, s.Rule)
+	}
+	return rules
+}
+
+// Merge add keys of o into t like the function Merge
+func (t *Tree) Merge(o *Tree) error {
+	m, err := Merge(t, o)
+	if err != nil {
+		return err
+	}
+	*t = *m
+	return nil
+}
+
+// Merge return a new tree with keys of t and o, both must be built with the same separator rules, nodes keep what
+// every source contributes. Neither tree is modified, so frozen trees can be merged without cloning. Single child
+// nodes need to be merged again, and children folded by either tree are merged as keys named OtherSegment.
+// The merged tree is limited by the shallower max depth of both
+func Merge(t, o *Tree) (*Tree, error) {
+	if !reflect.DeepEqual(t.rules(), o.rules()) {
+		return nil, fmt.Errorf("tree %s of source %q has different separator rules from source %q",
+			o.segmentString(rootID), o.source, t.source)
+	}
+	m := NewWithRules(t.segmentString(rootID), t.rules())
+	m.maxDepth = t.maxDepth
+	if o.maxDepth > 0 && (m.maxDepth == 0 || o.maxDepth < m.maxDepth) { // keys deeper are aggregated in either tree
+		m.maxDepth = o.maxDepth
+	}
+	m.source = t.source
+	m.attribution = make(map[uint32][]sourceStat)
+	m.sampling = mergeSampling(t, o)
+	if m.sampling != nil {
+		m.strata = make(map[uint32][]stratumStat)
+	}
+	m.addTree(t, "", 0)
+	m.addTree(o, "", len(t.stratumSamplings()))
+	return m, nil
+}
+
+// Combine return a tree named name with keys of all trees built with the same separator rules,
+// nodes are broken down by names of the trees instead of sources, e.g. a tree of all key types
+func Combine(name string, trees ...*Tree) (*Tree, error) {
+	if len(trees) == 0 {
+		return nil, fmt.Errorf("no tree to combine")
+	}
+	first := trees[0]
+	c := NewWithRules(name, first.rules())
+	c.maxDepth = first.maxDepth
+	c.source = first.source
+	c.attribution = make(map[uint32][]sourceStat)
+	for _, t := range trees {
+		if !reflect.DeepEqual(t.rules(), first.rules()) {
+			return nil, fmt.Errorf("tree %s has different separator rules from tree %s",
+				t.segmentString(rootID), first.segmentString(rootID))
+		}
+	}
+	c.sampling = combineSampling(trees)
+	if c.sampling != nil && len(c.sampling.Strata) > 0 {
+		c.strata = make(map[uint32][]stratumStat)
+	}
+	for _, t := range trees {
+		c.addTree(t, t.segmentString(rootID), 0)
+	}
+	return c, nil
+}
+
+// mergeSampling return sampling with strata of both trees instead of pooling them, which would extrapolate
+// a fully scanned tree by the fraction of a sampled one
+func mergeSampling(t, o *Tree) *Sampling {
+	if t.sampling == nil && o.sampling == nil {
+		return nil
+	}
+	return sumStrata(append(append([]*Sampling(nil), t.stratumSamplings()...), o.stratumSamplings()...))
+}
+
+// combineSampling return sampling of the first sampled tree, strata of merged trees are aligned by index,
+// fully scanned strata sum up keys of all trees while sampled ones are shared by them
+func combineSampling(trees []*Tree) *Sampling {
+	var first *Sampling
+	for _, t := range trees {
+		if t.sampling != nil {
+			first = t.sampling
+			break
+		}
+	}
+	if first == nil || len(first.Strata) == 0 {
+		return first
+	}
+	strata := make([]*Sampling, len(first.Strata))
+	for k, s := range first.Strata {
+		if s.Z != 0 {
+			strata[k] = s
+			continue
+		}
+		full := &Sampling{}
+		for _, t := range trees {
+			if t.sampling != nil && k < len(t.sampling.Strata) {
+				full.Population += t.sampling.Strata[k].Population
+				full.SampleNum += t.sampling.Strata[k].SampleNum
+			}
+		}
+		strata[k] = full
+	}
+	return sumStrata(strata)
+}
+
+// sumStrata return sampling of strata with their total population and sample num
+func sumStrata(strata []*Sampling) *Sampling {
+	s := &Sampling{Strata: strata}
+	for _, stratum := range strata {
+		s.Population += stratum.Population
+		s.SampleNum += stratum.SampleNum
+		if s.Z < stratum.Z {
+			s.Z = stratum.Z
+		}
+	}
+	return s
+}
+
+// addTree add keys of o to t by their own size and key num, which don't belong to children,
+// all keys are attributed to source if it is not empty, otherwise to sources of o.
+// Strata of o are added from stratum of t if t is merged from sampled trees
+func (t *Tree) addTree(o *Tree, source string, stratum int) {
+	var index []int
+	if source != "" {
+		index = append(index, t.sourceIndex(source))
+	} else {
+		for _, name := range o.Sources() {
+			index = append(index, t.sourceIndex(name))
+		}
+	}
+	byOwnSource := source == "" && o.attribution != nil
+	var walk func(id uint32, key []byte)
+	walk = func(id uint32, key []byte) {
+		n := o.node(id)
+		ownSize, ownKeyNum := n.size, n.keyNum
+		ownSquares := o.ownSquares(id)
+		var ownStrata []stratumStat
+		if t.strata != nil {
+			ownStrata = o.ownStrata(id)
+		}
+		var ownStats []sourceStat
+		if o.attribution != nil {
+			ownStats = append(ownStats, o.attribution[id]...)
+		}
+		for child := n.firstChild; child != rootID; child = o.node(child).nextSibling {
+			c := o.node(child)
+			ownSize -= c.size
+			ownKeyNum -= c.keyNum
+			if o.attribution != nil {
+				for i, stat := range o.attribution[child] {
+					ownStats[i].size -= stat.size
+					ownStats[i].keyNum -= stat.keyNum
+				}
+			}
+			walk(child, append(key, o.segment(child)...))
+		}
+		if id == rootID || ownSize == 0 && ownKeyNum == 0 {
+			return
+		}
+		if !byOwnSource {
+			t.stratifyPath(t.addWeight(string(key), ownSize, ownKeyNum, ownSquares, index[0]), stratum, ownStrata)
+			return
+		}
+		for i, stat := range ownStats {
+			if stat.size != 0 || stat.keyNum != 0 { // squares and strata are not by source, add them with the first one
+				t.stratifyPath(t.addWeight(string(key), stat.size, stat.keyNum, ownSquares, index[i]), stratum, ownStrata)
+				ownSquares, ownStrata = 0, nil
+			}
+		}
+	}
+	walk(rootID, nil)
+}
+
+// addWeight add size and key num to the node of key and its ancestors like AddKey, attributed to source src,
+// squares is the sum of squared size of the keys. It return the node which the keys are added to
+func (t *Tree) addWeight(key string, size, keyNum int64, squares float64, src int) uint32 {
+	id := rootID
+	n := t.mut(id)
+	n.size += size
+	n.keyNum += keyNum
+	t.attribute(id, src, size, keyNum)
+	left := 0
+	for depth := 0; left < len(key); depth++ {
+		right := t.segmentEnd(key, left, depth)
+		child, ok := t.child(id, key[left:right])
+		if !ok {
+			child = t.newNode(id, key[left:right])
+		}
+		last := right == len(key) || t.maxDepth > 0 && depth+1 >= t.maxDepth
+		if last {
+			t.addSquares(child, squares, keyNum)
+		}
+		c := t.mut(child)
+		c.size += size
+		c.keyNum += keyNum
+		t.attribute(child, src, size, keyNum)
+		if last {
+			return child
+		}
+		id = child
+		left = right
+	}
+	return id
+}
+
+// stratifyPath add stats of strata from stratum to node and its ancestors
+func (t *Tree) stratifyPath(id uint32, stratum int, stats []stratumStat) {
+	for k, stat := range stats {
+		for ancestor := id; ; ancestor = t.node(ancestor).parent {
+			t.stratify(ancestor, stratum+k, stat)
+			if ancestor == rootID {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package tree
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rule split key segments at depth from MinDepth to MaxDepth by any of Separators, depth of the first segment is 0
+type Rule struct {
+	MinDepth   int      `json:"min_depth"`
+	MaxDepth   int      `json:"max_depth"` // -1 for no limit
+	Separators []string `json:"separators"`
+}
+
+// ParseRules parse rules like "0-2=: .;3-=::", rules are separated by ';', depth range and separators by '=',
+// separators by space, the first rule matching the depth of a segment is applied
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, ruleStr := range strings.Split(s, ";") {
+		if ruleStr == "" {
+			continue
+		}
+		parts := strings.SplitN(ruleStr, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("rule %q has no '='", ruleStr)
+		}
+		depths := strings.SplitN(parts[0], "-", 2)
+		rule := Rule{MaxDepth: -1, Separators: strings.Fields(parts[1])}
+		var err error
+		if rule.MinDepth, err = strconv.Atoi(depths[0]); err != nil {
+			return nil, fmt.Errorf("rule %q min depth: %v", ruleStr, err)
+		}
+		if len(depths) == 1 {
+			rule.MaxDepth = rule.MinDepth
+		} else if depths[1] != "" {
+			if rule.MaxDepth, err = strconv.Atoi(depths[1]); err != nil {
+				return nil, fmt.Errorf("rule %q max depth: %v", ruleStr, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ByteRules return a rule for all depths which split by every byte of separators
+func ByteRules(separators []byte) []Rule {
+	rule := Rule{MaxDepth: -1}
+	for _, separator := range separators {
+		rule.Separators = append(rule.Separators, string(separator))
+	}
+	return []Rule{rule}
+}
+
+// splitter is the compiled Rule
+type splitter struct {
+	Rule
+	single [256]bool // single byte separators
+	first  [256]bool // first byte of multi byte separators
+	multi  []string  // multi byte separators, longest first
+}
+
+func newSplitter(rule Rule) *splitter {
+	s := &splitter{Rule: rule}
+	for _, separator := range rule.Separators {
+		switch len(separator) {
+		case 0:
+		case 1:
+			s.single[separator[0]] = true
+		default:
+			s.first[separator[0]] = true
+			s.multi = append(s.multi, separator)
+		}
+	}
+	sort.Slice(s.multi, func(i, j int) bool {
+		return len(s.multi[i]) > len(s.multi[j])
+	})
+	return s
+}
+
+func (s *splitter) match(depth int) bool {
+	return depth >= s.MinDepth && (s.MaxDepth < 0 || depth <= s.MaxDepth)
+}
+
+// separatorEnd return the end of separator starting at pos, 0 if no separator
+func (s *splitter) separatorEnd(key string, pos int) int {
+	if s.first[key[pos]] {
+		for _, separator := range s.multi {
+			if strings.HasPrefix(key[pos:], separator) {
+				return pos + len(separator)
+			}
+		}
+	}
+	if s.single[key[pos]] {
+		return pos + 1
+	}
+	return 0
+}
+
+// segmentEnd return the end of segment searching separator from pos, which is the depth-th segment of key.
+// The segment includes its separator, or end at the end of key
+func (t *Tree) segmentEnd(key string, pos, depth int) int {
+	var s *splitter
+	for _, splitter := range t.splitters {
+		if splitter.match(depth) {
+			s = splitter
+			break
+		}
+	}
+	if s == nil {
+		return len(key)
+	}
+	for ; pos < len(key); pos++ {
+		if end := s.separatorEnd(key, pos); end > 0 {
+			return end
+		}
+	}
+	return len(key)
+}
@@ -2,222 +2,643 @@ package tree
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"sort"
+	"sync/atomic"
+
+	"github.com/iccolo/rma/analyzer/escape"
+)
+
+// nodes are allocated in chunks, so that pointers to them keep valid while the tree grows
+const (
+	chunkBits = 12
+	chunkSize = 1 << chunkBits
+)
+
+// segment bytes are appended to chunks, a segment is referred by chunk, offset and length packed in uint64
+const (
+	segmentChunkBits = 20
+	segmentChunkSize = 1 << segmentChunkBits
+	segmentLenBits   = 20
+	bigSegmentLen    = 1<<segmentLenBits - 1 // segments not shorter than it are stored in bigSegments
+	minSegmentChunk  = 4 << 10
 )
 
 func New(name string, separators []byte) *Tree {
+	return NewWithRules(name, ByteRules(separators))
+}
+
+// NewWithRules return tree splitting keys by ordered separator rules
+func NewWithRules(name string, rules []Rule) *Tree {
 	t := &Tree{
-		root: &Node{
-			Segment: name,
-			Child:   make(map[string]*Node),
-		},
-		separators: make(map[byte]bool),
+		edges:      make(map[uint64]uint32),
+		collisions: make(map[uint64][]uint32),
 	}
-	for _, separator := range separators {
-		t.separators[separator] = true
+	for _, rule := range rules {
+		t.splitters = append(t.splitters, newSplitter(rule))
 	}
+	t.newNode(rootID, name)
 	return t
 }
 
+// Tree store nodes in an arena indexed by uint32 and segments in byte chunks instead of pointers,
+// strings and maps per node, children are found by a single edge map keyed by hash of parent and segment
 type Tree struct {
-	root       *Node
-	nodeNum    int64
-	separators map[byte]bool
+	chunks        [][]node
+	chunkGens     []uint64 // generation of every chunk, chunks of other generations are shared and copied on write
+	gen           uint64   // atomic, so that trees read by many goroutines can be frozen or cloned concurrently
+	nodeNum       int64    // allocated node num, including dropped ones
+	freeHead      uint32   // dropped nodes linked by nextSibling, reused by newNode
+	maxDepth      int      // keys deeper than it are aggregated into the node at it, 0 for no limit
+	segmentChunks [][]byte
+	bigSegments   []string
+	edges         map[uint64]uint32   // child by hash of parent id and segment, nil for frozen trees
+	collisions    map[uint64][]uint32 // children whose hash collide with the one in edges
+	splitters     []*splitter
+	sampling      *Sampling // not nil if tree is built from sampled keys
+	source        string    // source of keys added by AddKey
+	sources       []string
+	attribution   map[uint32][]sourceStat  // node stats by index of sources, not nil if tree is merged
+	squares       map[uint32]float64       // sum of squared key sizes owned by nodes aggregating more than one key
+	strata        map[uint32][]stratumStat // node stats by index of Sampling.Strata, not nil if sampled tree is merged
+}
+
+const rootID uint32 = 0 // root is never a child, so 0 also means no child or sibling
+
+type node struct {
+	size        int64  // total size of keys in current and child node
+	keyNum      int64  // child key num
+	segment     uint64 // reference of segment bytes
+	parent      uint32
+	firstChild  uint32
+	nextSibling uint32
 }
 
+// Node is a read only view of a tree node
 type Node struct {
-	Segment string           // key segment
-	KeyNum  int64            // child key num
-	Size    int64            // total size of keys in current and child node
-	Child   map[string]*Node // child node
+	Segment  string // key segment
+	KeyNum   int64  // child key num
+	Size     int64  // total size of keys in current and child node
+	ChildNum int    // child node num
+
+	tree *Tree
+	id   uint32
 }
 
-func (t *Tree) AddKey(key string, size int64) {
-	tmpRoot := t.root
-	left := 0
-	right := left
-	for right < len(key) {
-		// key end
-		if right == len(key)-1 {
-			segment := key[left : right+1]
-			if child, ok := tmpRoot.Child[segment]; ok { // exists duplicate key, cover duplicate key size
-				tmpRoot.Size += size - child.Size
-				child.Size = size
-			} else {
-				if tmpRoot.Child == nil {
-					tmpRoot.Child = make(map[string]*Node)
-				}
-				newNode := &Node{
-					Segment: segment,
-					Size:    size,
-					KeyNum:  1,
-				}
-				tmpRoot.Child[segment] = newNode
-				tmpRoot.Size += size
-				tmpRoot.KeyNum++
-				tmpRoot = newNode
-				t.nodeNum++
-			}
-			break // stop circulation by key end
+// node return node for reading, use mut for writing
+func (t *Tree) node(id uint32) *node {
+	return &t.chunks[id>>chunkBits][id&(chunkSize-1)]
+}
+
+// mut return node for writing, its chunk is copied first if it is shared with another generation.
+// Pointers returned keep valid until the tree is frozen again
+func (t *Tree) mut(id uint32) *node {
+	i := id >> chunkBits
+	if gen := atomic.LoadUint64(&t.gen); t.chunkGens[i] != gen {
+		t.chunks[i] = append([]node(nil), t.chunks[i]...)
+		t.chunkGens[i] = gen
+	}
+	return &t.chunks[i][id&(chunkSize-1)]
+}
+
+// newNode allocate a node and link it to parent, root is allocated with parent rootID
+func (t *Tree) newNode(parent uint32, segment string) uint32 {
+	var id uint32
+	if t.freeHead != rootID {
+		id = t.freeHead
+		t.freeHead = t.node(id).nextSibling
+		*t.mut(id) = node{}
+	} else {
+		id = uint32(t.nodeNum)
+		if id&(chunkSize-1) == 0 {
+			t.chunks = append(t.chunks, make([]node, chunkSize))
+			t.chunkGens = append(t.chunkGens, atomic.LoadUint64(&t.gen))
 		}
+		t.nodeNum++
+	}
+	n := t.mut(id)
+	n.segment = t.storeSegment(segment)
+	n.parent = parent
+	if id != rootID {
+		p := t.mut(parent)
+		n.nextSibling = p.firstChild
+		p.firstChild = id
+		t.addEdge(hashEdge(parent, segment), id)
+	}
+	return id
+}
 
-		// is not key separator
-		if _, ok := t.separators[key[right]]; !ok {
-			right++
-			continue
+// storeSegment copy segment into chunks, so that it doesn't refer to the whole key
+func (t *Tree) storeSegment(segment string) uint64 {
+	if len(segment) >= bigSegmentLen {
+		t.bigSegments = append(t.bigSegments, string([]byte(segment)))
+		return uint64(len(t.bigSegments)-1)<<segmentLenBits | bigSegmentLen
+	}
+	last := len(t.segmentChunks) - 1
+	if last < 0 || len(t.segmentChunks[last])+len(segment) > cap(t.segmentChunks[last]) {
+		size := minSegmentChunk
+		if last >= 0 {
+			size = cap(t.segmentChunks[last]) * 2
+		}
+		if size > segmentChunkSize {
+			size = segmentChunkSize
 		}
+		t.segmentChunks = append(t.segmentChunks, make([]byte, 0, size))
+		last++
+	}
+	offset := len(t.segmentChunks[last])
+	t.segmentChunks[last] = append(t.segmentChunks[last], segment...)
+	return (uint64(last)<<segmentChunkBits|uint64(offset))<<segmentLenBits | uint64(len(segment))
+}
 
-		// is key separator
-		segment := key[left : right+1]
-		if child, ok := tmpRoot.Child[segment]; ok { // exist duplicate segment, find the child node
-			tmpRoot.Size += size
-			tmpRoot.KeyNum++
-			tmpRoot = child
-		} else {
-			newNode := &Node{
-				Segment: segment,
+// segment return bytes of node segment, which must not be modified
+func (t *Tree) segment(id uint32) []byte {
+	ref := t.node(id).segment
+	length := ref & (1<<segmentLenBits - 1)
+	ref >>= segmentLenBits
+	if length == bigSegmentLen {
+		return []byte(t.bigSegments[ref])
+	}
+	offset := ref & (segmentChunkSize - 1)
+	return t.segmentChunks[ref>>segmentChunkBits][offset : offset+length]
+}
+
+func (t *Tree) segmentString(id uint32) string {
+	return string(t.segment(id))
+}
+
+// hashEdge is FNV-1a of parent id and segment
+func hashEdge(parent uint32, segment string) uint64 {
+	const prime = 1099511628211
+	h := uint64(14695981039346656037)
+	for i := 0; i < 4; i++ {
+		h = (h ^ uint64(byte(parent>>(8*i)))) * prime
+	}
+	for i := 0; i < len(segment); i++ {
+		h = (h ^ uint64(segment[i])) * prime
+	}
+	return h
+}
+
+func (t *Tree) addEdge(h uint64, id uint32) {
+	if t.edges == nil {
+		return
+	}
+	if _, ok := t.edges[h]; ok {
+		t.collisions[h] = append(t.collisions[h], id)
+		return
+	}
+	t.edges[h] = id
+}
+
+func (t *Tree) removeEdge(h uint64, id uint32) {
+	if t.edges == nil {
+		return
+	}
+	collisions := t.collisions[h]
+	if t.edges[h] == id {
+		if len(collisions) == 0 {
+			delete(t.edges, h)
+			return
+		}
+		t.edges[h] = collisions[0]
+		collisions = collisions[1:]
+	} else {
+		for i, collision := range collisions {
+			if collision == id {
+				collisions = append(collisions[:i:i], collisions[i+1:]...)
+				break
 			}
-			if tmpRoot.Child == nil {
-				tmpRoot.Child = make(map[string]*Node)
+		}
+	}
+	if len(collisions) == 0 {
+		delete(t.collisions, h)
+	} else {
+		t.collisions[h] = collisions
+	}
+}
+
+// child return child of parent by segment, siblings are scanned if the tree is frozen
+func (t *Tree) child(parent uint32, segment string) (uint32, bool) {
+	if t.edges == nil {
+		for child := t.node(parent).firstChild; child != rootID; child = t.node(child).nextSibling {
+			if string(t.segment(child)) == segment {
+				return child, true
 			}
-			tmpRoot.Child[segment] = newNode
-			tmpRoot.Size += size
-			tmpRoot.KeyNum++
-			tmpRoot = newNode
-			t.nodeNum++
 		}
-		left = right + 1
-		right = left
+		return 0, false
+	}
+	h := hashEdge(parent, segment)
+	id, ok := t.edges[h]
+	if !ok {
+		return 0, false
+	}
+	if t.node(id).parent == parent && string(t.segment(id)) == segment {
+		return id, true
+	}
+	for _, id := range t.collisions[h] {
+		if t.node(id).parent == parent && string(t.segment(id)) == segment {
+			return id, true
+		}
 	}
+	return 0, false
 }
 
-func (t *Tree) GetSize(keyPrefix string) int64 {
-	size := int64(0)
-	tmpRoot := t.root
+func (t *Tree) childNum(id uint32) int {
+	num := 0
+	for child := t.node(id).firstChild; child != rootID; child = t.node(child).nextSibling {
+		num++
+	}
+	return num
+}
+
+// view return the read only view of node
+func (t *Tree) view(id uint32) *Node {
+	n := t.node(id)
+	return &Node{
+		Segment:  t.segmentString(id),
+		KeyNum:   n.keyNum,
+		Size:     n.size,
+		ChildNum: t.childNum(id),
+		tree:     t,
+		id:       id,
+	}
+}
+
+func (t *Tree) AddKey(key string, size int64) {
+	src := 0
+	if t.attribution != nil {
+		src = t.sourceIndex(t.source)
+	}
+	// keys added to a merged tree belong to the first stratum
+	stat := stratumStat{keyNum: 1, size: size, squares: float64(size) * float64(size)}
+	tmpRoot := rootID
 	left := 0
-	right := left
-	for right < len(keyPrefix) {
+	for depth := 0; left < len(key); depth++ {
+		right := t.segmentEnd(key, left, depth)
+		segment := key[left:right]
+		parent := t.mut(tmpRoot)
 		// key end
-		if right == len(keyPrefix)-1 {
-			if node, ok := tmpRoot.Child[keyPrefix[left:right+1]]; ok {
-				size = node.Size
+		if right == len(key) {
+			if child, ok := t.child(tmpRoot, segment); ok { // exists duplicate key, cover duplicate key size
+				c := t.mut(child)
+				t.attribute(tmpRoot, src, size-c.size, 0)
+				t.attribute(child, src, size-c.size, 0)
+				cover := stratumStat{size: size - c.size, squares: stat.squares - float64(c.size)*float64(c.size)}
+				t.stratify(tmpRoot, 0, cover)
+				t.stratify(child, 0, cover)
+				parent.size += size - c.size
+				c.size = size
+			} else {
+				child = t.newNode(tmpRoot, segment)
+				c := t.mut(child)
+				c.size = size
+				c.keyNum = 1
+				t.attribute(child, src, size, 1)
+				t.attribute(tmpRoot, src, size, 1)
+				t.stratify(child, 0, stat)
+				t.stratify(tmpRoot, 0, stat)
+				parent.size += size
+				parent.keyNum++
 			}
-			break
-		}
-		// is not key separator
-		if _, ok := t.separators[keyPrefix[right]]; !ok {
-			right++
-			continue
+			break // stop circulation by key end
 		}
+
 		// is key separator
-		segment := keyPrefix[left : right+1]
-		if child, ok := tmpRoot.Child[segment]; ok { // exist duplicate segment, find the child node
-			tmpRoot = child
-			left = right + 1
-			right = left
-		} else {
-			right++ // even if not find segment split by separator, continue find longer segment
+		child, ok := t.child(tmpRoot, segment)
+		if !ok { // not exist segment, create the child node
+			child = t.newNode(tmpRoot, segment)
 		}
+		parent.size += size
+		parent.keyNum++
+		t.attribute(tmpRoot, src, size, 1)
+		t.stratify(tmpRoot, 0, stat)
+		if t.maxDepth > 0 && depth+1 >= t.maxDepth { // aggregate the rest of key into the node at max depth
+			t.addSquares(child, float64(size)*float64(size), 1)
+			c := t.mut(child)
+			c.size += size
+			c.keyNum++
+			t.attribute(child, src, size, 1)
+			t.stratify(child, 0, stat)
+			break
+		}
+		tmpRoot = child
+		left = right
+	}
+}
+
+// SetMaxDepth stop descending past depth, keys deeper are aggregated into their prefix node at depth, 0 for no limit
+func (t *Tree) SetMaxDepth(depth int) {
+	t.maxDepth = depth
+}
+
+func (t *Tree) GetSize(keyPrefix string) int64 {
+	if id, ok := t.find(keyPrefix); ok {
+		return t.node(id).size
 	}
-	return size
+	return 0
 }
 
 func (t *Tree) Expand(keyPrefix string) map[string]*Node {
-	if keyPrefix == "" {
-		return t.root.Child
+	id := rootID
+	if keyPrefix != "" {
+		var ok bool
+		if id, ok = t.find(keyPrefix); !ok {
+			return nil
+		}
+	}
+	nodes := make(map[string]*Node)
+	for child := t.node(id).firstChild; child != rootID; child = t.node(child).nextSibling {
+		view := t.view(child)
+		nodes[view.Segment] = view
 	}
+	return nodes
+}
 
-	tmpRoot := t.root
+// find return the node of key prefix
+func (t *Tree) find(keyPrefix string) (uint32, bool) {
+	tmpRoot := rootID
 	left := 0
 	right := left
-	for right < len(keyPrefix) {
+	for depth := 0; right < len(keyPrefix); depth++ {
+		right = t.segmentEnd(keyPrefix, right, depth)
 		// key end
-		if right == len(keyPrefix)-1 {
-			if node, ok := tmpRoot.Child[keyPrefix[left:right+1]]; ok {
-				return node.Child
-			}
-			return nil
-		}
-		// is not key separator
-		if _, ok := t.separators[keyPrefix[right]]; !ok {
-			right++
-			continue
+		if right == len(keyPrefix) {
+			return t.child(tmpRoot, keyPrefix[left:right])
 		}
 		// is key separator
-		segment := keyPrefix[left : right+1]
-		if child, ok := tmpRoot.Child[segment]; ok { // exist duplicate segment, find the child node
+		if child, ok := t.child(tmpRoot, keyPrefix[left:right]); ok { // exist duplicate segment, find the child node
 			tmpRoot = child
-			left = right + 1
-			right = left
-		} else {
-			right++ // even if not find segment split by separator, continue find longer segment
+			left = right
 		}
+		// even if not find segment split by separator, continue find longer segment
 	}
-	return nil
+	return 0, false
 }
 
 func (t *Tree) GetKeyNum() int64 {
-	return t.root.KeyNum
+	return t.node(rootID).keyNum
 }
 
 func (t *Tree) GetTotalSize() int64 {
-	return t.root.Size
+	return t.node(rootID).size
 }
 
+// SetSampling mark the tree as built from sampled keys, stats of strata are dropped if sampling has none
+func (t *Tree) SetSampling(sampling *Sampling) {
+	t.sampling = sampling
+	if sampling == nil || len(sampling.Strata) == 0 {
+		t.strata = nil
+	}
+}
+
+func (t *Tree) GetSampling() *Sampling {
+	return t.sampling
+}
+
+// Estimate return extrapolated totals of the whole tree
+func (t *Tree) Estimate() *Estimate {
+	return t.sampling.Estimate(t.view(rootID))
+}
+
+// MergeSingleChildNode merge node having only one child and not being a key itself into the child
 func (t *Tree) MergeSingleChildNode() {
-	t.mergeSingleChildNode(t.root)
+	t.mergeSingleChildNode(rootID)
 }
 
-func (t *Tree) mergeSingleChildNode(node *Node) {
-	for {
-		singleChildNode := findSingleChildNode(node)
-		if singleChildNode == nil { // has no single child node in Child
-			break
+func (t *Tree) mergeSingleChildNode(id uint32) {
+	for child := t.node(id).firstChild; child != rootID; child = t.node(child).nextSibling {
+		for {
+			c := t.node(child)
+			grandChild := c.firstChild
+			if grandChild == rootID || t.node(grandChild).nextSibling != rootID || t.node(grandChild).keyNum != c.keyNum {
+				break
+			}
+			segment := t.segmentString(child) + t.segmentString(grandChild)
+			if _, ok := t.child(id, segment); ok { // merged segment is used by another child
+				break
+			}
+			t.replaceChild(id, child, grandChild, segment)
+			child = grandChild
 		}
-		grandChild := findOneChildNode(singleChildNode)
-		grandChild.Segment = singleChildNode.Segment + grandChild.Segment
-		delete(node.Child, singleChildNode.Segment)
-		node.Child[grandChild.Segment] = grandChild
+		t.mergeSingleChildNode(child)
 	}
+}
 
-	for _, child := range node.Child {
-		t.mergeSingleChildNode(child)
+// replaceChild replace child of parent with the grand child which is renamed to segment
+func (t *Tree) replaceChild(parent, child, grandChild uint32, segment string) {
+	g := t.mut(grandChild)
+	t.removeEdge(hashEdge(child, t.segmentString(grandChild)), grandChild)
+	g.segment = t.storeSegment(segment)
+	g.parent = parent
+	c := t.mut(child)
+	g.nextSibling = c.nextSibling
+	c.nextSibling = grandChild // keep grand child in the sibling list until child is unlinked
+	c.firstChild = rootID
+	t.addEdge(hashEdge(parent, segment), grandChild)
+	t.removeChild(parent, child)
+}
+
+// removeChild unlink child from parent and drop the subtree of child
+func (t *Tree) removeChild(parent, child uint32) {
+	c := t.node(child)
+	t.removeEdge(hashEdge(parent, t.segmentString(child)), child)
+	p := t.mut(parent)
+	if p.firstChild == child {
+		p.firstChild = c.nextSibling
+	} else {
+		prev := p.firstChild
+		for t.node(prev).nextSibling != child {
+			prev = t.node(prev).nextSibling
+		}
+		t.mut(prev).nextSibling = c.nextSibling
+	}
+	t.dropSubtree(child)
+}
+
+// dropSubtree put node and its descendants to the free list, edges of descendants are removed
+func (t *Tree) dropSubtree(id uint32) {
+	for child := t.node(id).firstChild; child != rootID; {
+		next := t.node(child).nextSibling
+		t.removeEdge(hashEdge(id, t.segmentString(child)), child)
+		t.dropSubtree(child)
+		child = next
+	}
+	*t.mut(id) = node{nextSibling: t.freeHead}
+	t.freeHead = id
+	delete(t.attribution, id)
+	delete(t.squares, id)
+	delete(t.strata, id)
+}
+
+// OtherSegment is the segment of the synthetic node folding small children
+const OtherSegment = "(other)"
+
+// Fold merge children smaller than minSize or with less keys than minKeyNum into a synthetic OtherSegment child,
+// their subtrees are dropped so that totals of ancestors keep exact, 0 to disable a threshold
+func (t *Tree) Fold(minSize, minKeyNum int64) {
+	if minSize <= 0 && minKeyNum <= 0 {
+		return
 	}
+	t.fold(rootID, minSize, minKeyNum)
 }
 
-func findSingleChildNode(node *Node) *Node {
-	for _, child := range node.Child {
-		if len(child.Child) == 1 {
-			return child
+func (t *Tree) fold(id uint32, minSize, minKeyNum int64) {
+	other, hasOther := t.child(id, OtherSegment)
+	var small []uint32
+	for child := t.node(id).firstChild; child != rootID; child = t.node(child).nextSibling {
+		if hasOther && child == other {
+			continue
+		}
+		if c := t.node(child); c.size < minSize || c.keyNum < minKeyNum {
+			small = append(small, child)
+		}
+	}
+	// folding a single child only rename it
+	if len(small) >= 2 || len(small) == 1 && hasOther {
+		if !hasOther {
+			other = t.newNode(id, OtherSegment)
+		}
+		for _, child := range small {
+			t.addSquares(other, t.sizeSquareSum(child), t.node(child).keyNum)
+			o, c := t.mut(other), t.node(child)
+			o.size += c.size
+			o.keyNum += c.keyNum
+			for i, stat := range t.attribution[child] {
+				t.attribute(other, i, stat.size, stat.keyNum)
+			}
+			for k, stat := range t.strata[child] {
+				t.stratify(other, k, stat)
+			}
+			t.removeChild(id, child)
+		}
+	}
+	for child := t.node(id).firstChild; child != rootID; child = t.node(child).nextSibling {
+		if child != other {
+			t.fold(child, minSize, minKeyNum)
 		}
 	}
-	return nil
 }
 
-func findOneChildNode(node *Node) *Node {
-	for _, child := range node.Child {
-		return child
+// sortedChildren return children of node sorted by segment
+func (t *Tree) sortedChildren(id uint32) []uint32 {
+	var children []uint32
+	for child := t.node(id).firstChild; child != rootID; child = t.node(child).nextSibling {
+		children = append(children, child)
 	}
-	return nil
+	sort.Slice(children, func(i, j int) bool {
+		return string(t.segment(children[i])) < string(t.segment(children[j]))
+	})
+	return children
 }
 
 // Print 打印字符串树
 func (t *Tree) Print() {
-	fmt.Printf("total size:%v, total key num:%v\n", t.root.Size, t.root.KeyNum)
-	t.root.print(0)
+	t.Fprint(os.Stdout, escape.Auto)
+}
+
+// Fprint 打印字符串树到 w，segment 和 source 按 mode 转义
+func (t *Tree) Fprint(w io.Writer, mode escape.Mode) {
+	fmt.Fprintf(w, "total size:%v, total key num:%v\n", t.GetTotalSize(), t.GetKeyNum())
+	t.print(w, mode, rootID, 0)
 }
 
-// print 打印节点
-func (n *Node) print(level int) {
-	fmt.Printf("%*s%s%*s%d\n", level*2, "", n.Segment, 2, "", n.Size)
-	segments := make([]string, 0, len(n.Child))
-	for segment := range n.Child {
-		segments = append(segments, segment)
+// print 打印节点，抽样时附带估算值与置信区间
+func (t *Tree) print(w io.Writer, mode escape.Mode, id uint32, level int) {
+	n := t.node(id)
+	segment := mode.Escape(t.segmentString(id))
+	if t.sampling != nil {
+		e := t.sampling.Estimate(t.view(id))
+		fmt.Fprintf(w, "%*s%s%*s%d  est size:%.0f [%.0f, %.0f] est key num:%.0f [%.0f, %.0f]\n", level*2, "", segment, 2, "", n.size,
+			e.Size, e.SizeLow, e.SizeHigh, e.KeyNum, e.KeyNumLow, e.KeyNumHigh)
+	} else {
+		fmt.Fprintf(w, "%*s%s%*s%d\n", level*2, "", segment, 2, "", n.size)
 	}
-	sort.Slice(segments, func(i, j int) bool {
-		return segments[i] < segments[j]
-	})
-	for _, segment := range segments {
-		n.Child[segment].print(level + 1)
+	if t.attribution != nil {
+		for _, stat := range t.view(id).Sources() {
+			fmt.Fprintf(w, "%*s@%s  %d  key num:%d\n", level*2+2, "", mode.Escape(stat.Source), stat.Size, stat.KeyNum)
+		}
+	}
+	for _, child := range t.sortedChildren(id) {
+		t.print(w, mode, child, level+1)
+	}
+}
+
+// generation of trees sharing chunks, every frozen tree and its origin get new generations
+var generation uint64
+
+// Freeze return a copy of the tree sharing node and segment chunks with it, both copy a chunk before their first
+// write to it, so it takes time of chunk num instead of node num. The copy has no edges and finds children by
+// scanning siblings, which is fine for reading and finalizing but slow for adding keys, use Clone for that
+func (t *Tree) Freeze() *Tree {
+	c := t.share()
+	atomic.StoreUint64(&t.gen, atomic.AddUint64(&generation, 1))
+	c.gen = atomic.AddUint64(&generation, 1)
+	return c
+}
+
+// share copy fields of t except edges, node chunks are shared
+func (t *Tree) share() *Tree {
+	c := &Tree{
+		chunks:      append([][]node(nil), t.chunks...),
+		chunkGens:   append([]uint64(nil), t.chunkGens...),
+		nodeNum:     t.nodeNum,
+		freeHead:    t.freeHead,
+		maxDepth:    t.maxDepth,
+		bigSegments: append([]string(nil), t.bigSegments...),
+		splitters:   t.splitters,
+		sampling:    t.sampling,
+		source:      t.source,
+		sources:     append([]string(nil), t.sources...),
+	}
+	c.segmentChunks = make([][]byte, len(t.segmentChunks))
+	for i, chunk := range t.segmentChunks {
+		c.segmentChunks[i] = chunk[:len(chunk):len(chunk)]
+	}
+	if t.attribution != nil {
+		c.attribution = make(map[uint32][]sourceStat, len(t.attribution))
+		for id, stats := range t.attribution {
+			c.attribution[id] = append([]sourceStat(nil), stats...)
+		}
+	}
+	if t.squares != nil {
+		c.squares = make(map[uint32]float64, len(t.squares))
+		for id, squares := range t.squares {
+			c.squares[id] = squares
+		}
+	}
+	if t.strata != nil {
+		c.strata = make(map[uint32][]stratumStat, len(t.strata))
+		for id, stats := range t.strata {
+			c.strata[id] = append([]stratumStat(nil), stats...)
+		}
+	}
+	return c
+}
+
+// Clone return a copy of the tree which can be read and modified independently like Freeze, with edges for
+// adding keys, which are rebuilt if the tree is frozen
+func (t *Tree) Clone() *Tree {
+	c := t.Freeze()
+	c.edges = make(map[uint64]uint32, len(t.edges))
+	c.collisions = make(map[uint64][]uint32, len(t.collisions))
+	if t.edges == nil {
+		c.buildEdges(rootID)
+		return c
+	}
+	for h, id := range t.edges {
+		c.edges[h] = id
+	}
+	for h, ids := range t.collisions {
+		c.collisions[h] = append([]uint32(nil), ids...)
+	}
+	return c
+}
+
+func (t *Tree) buildEdges(id uint32) {
+	for child := t.node(id).firstChild; child != rootID; child = t.node(child).nextSibling {
+		t.addEdge(hashEdge(id, t.segmentString(child)), child)
+		t.buildEdges(child)
 	}
 }
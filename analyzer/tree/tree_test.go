@@ -1,6 +1,8 @@
 package tree
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -17,3 +19,389 @@ func TestTree(t *testing.T) {
 		t.Errorf("Expected size of 2, got %d", size)
 	}
 }
+
+func TestSamplingEstimate(t *testing.T) {
+	t1 := New("", []byte{':'})
+	t1.AddKey("a:1", 10)
+	t1.AddKey("a:2", 30)
+	t1.AddKey("b:1", 20)
+	a := t1.Expand("")["a:"]
+
+	// all keys sampled, estimate is exact
+	e := NewSampling(3, 3, 0.95).Estimate(a)
+	if e.KeyNum != 2 || e.KeyNumLow != 2 || e.KeyNumHigh != 2 || e.Size != 40 || e.SizeLow != 40 || e.SizeHigh != 40 {
+		t.Errorf("Expected exact estimate, got %+v", e)
+	}
+
+	// 4 keys sampled out of 400
+	e = NewSampling(400, 4, 0.95).Estimate(a)
+	if e.KeyNum != 200 || e.Size != 4000 {
+		t.Errorf("Expected key num 200 and size 4000, got %+v", e)
+	}
+	if e.KeyNumLow < 2 || e.KeyNumLow >= e.KeyNum || e.KeyNumHigh <= e.KeyNum {
+		t.Errorf("Unexpected key num interval %+v", e)
+	}
+	if e.SizeLow < 40 || e.SizeLow >= e.Size || e.SizeHigh <= e.Size {
+		t.Errorf("Unexpected size interval %+v", e)
+	}
+}
+
+func TestSeparatorRules(t *testing.T) {
+	rules, err := ParseRules("0-1=:: ||;2-=::")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t1 := NewWithRules("", rules)
+	t1.AddKey("app::user::42", 1)
+	t1.AddKey("app::user::43", 2)
+	t1.AddKey("a||b", 4)
+	t1.AddKey("app::x||y::z||w", 8)
+	if size := t1.GetSize("app::"); size != 11 {
+		t.Errorf("Expected size of 11, got %d", size)
+	}
+	if size := t1.GetSize("app::user::"); size != 3 {
+		t.Errorf("Expected size of 3, got %d", size)
+	}
+	if size := t1.GetSize("a||"); size != 4 {
+		t.Errorf("Expected size of 4, got %d", size)
+	}
+	// "||" splits at depth 1 but not at depth 2
+	if nodes := t1.Expand("app::x||"); len(nodes) != 1 || nodes["y::"] == nil {
+		t.Errorf("Expected child y::, got %v", nodes)
+	}
+	if nodes := t1.Expand("app::x||y::"); len(nodes) != 1 || nodes["z||w"] == nil {
+		t.Errorf("Expected child z||w, got %v", nodes)
+	}
+}
+
+func TestSameAsMapTree(t *testing.T) {
+	keys := benchKeys(10000)
+	t1 := New("", []byte{':'})
+	ref := &mapNode{}
+	for i, key := range keys {
+		t1.AddKey(key, int64(i))
+		ref.addKey(key, int64(i), ':')
+	}
+	var check func(prefix string, n *mapNode)
+	check = func(prefix string, n *mapNode) {
+		nodes := t1.Expand(prefix)
+		if len(nodes) != len(n.Child) {
+			t.Fatalf("Expected %d children of %q, got %d", len(n.Child), prefix, len(nodes))
+		}
+		for segment, child := range n.Child {
+			node := nodes[segment]
+			if node == nil || node.Size != child.Size || node.KeyNum != child.KeyNum || node.ChildNum != len(child.Child) {
+				t.Fatalf("Expected %+v of %q, got %+v", child, prefix+segment, node)
+			}
+			if size := t1.GetSize(prefix + segment); size != child.Size {
+				t.Fatalf("Expected size of %d of %q, got %d", child.Size, prefix+segment, size)
+			}
+			check(prefix+segment, child)
+		}
+	}
+	check("", ref)
+}
+
+func TestMergeSingleChildNode(t *testing.T) {
+	t1 := New("", []byte{':'})
+	t1.AddKey("a:b:c", 1)
+	t1.AddKey("a:b:d", 2)
+	t1.AddKey("x:y:z", 4)
+	t1.AddKey("k:", 8)
+	t1.AddKey("k:v", 16)
+	t1.MergeSingleChildNode()
+	nodes := t1.Expand("")
+	if len(nodes) != 3 || nodes["a:b:"] == nil || nodes["x:y:z"] == nil || nodes["k:"] == nil {
+		t.Fatalf("Expected a:b:, x:y:z and k:, got %v", nodes)
+	}
+	if size := t1.GetSize("a:b:"); size != 3 {
+		t.Errorf("Expected size of 3, got %d", size)
+	}
+	if size := t1.GetSize("k:"); size != 24 {
+		t.Errorf("Expected size of 24, got %d", size)
+	}
+	if total := t1.GetTotalSize(); total != 31 {
+		t.Errorf("Expected total size of 31, got %d", total)
+	}
+}
+
+func TestMaxDepthAndFold(t *testing.T) {
+	t1 := New("", []byte{':'})
+	t1.SetMaxDepth(2)
+	t1.AddKey("user:1:profile", 100)
+	t1.AddKey("user:1:session", 50)
+	t1.AddKey("user:2:profile", 3)
+	t1.AddKey("user:3:profile", 2)
+	t1.AddKey("cache", 1)
+	if nodes := t1.Expand("user:1:"); len(nodes) != 0 {
+		t.Errorf("Expected no child past max depth, got %v", nodes)
+	}
+	if node := t1.Expand("user:")["1:"]; node == nil || node.Size != 150 || node.KeyNum != 2 {
+		t.Errorf("Expected aggregated node of size 150 and key num 2, got %+v", node)
+	}
+
+	t1.Fold(10, 0)
+	nodes := t1.Expand("user:")
+	if len(nodes) != 2 || nodes["1:"] == nil || nodes[OtherSegment] == nil {
+		t.Fatalf("Expected 1: and %s, got %v", OtherSegment, nodes)
+	}
+	if other := nodes[OtherSegment]; other.Size != 5 || other.KeyNum != 2 {
+		t.Errorf("Expected folded size of 5 and key num 2, got %+v", other)
+	}
+	if total := t1.GetTotalSize(); total != 156 {
+		t.Errorf("Expected total size of 156, got %d", total)
+	}
+	// single small child at root is not folded
+	if nodes := t1.Expand(""); len(nodes) != 2 || nodes["cache"] == nil {
+		t.Errorf("Expected user: and cache, got %v", nodes)
+	}
+	// folded nodes are reused
+	t1.AddKey("user:4:profile", 1)
+	if num := t1.nodeNum; num != 7 {
+		t.Errorf("Expected 7 allocated nodes, got %d", num)
+	}
+}
+
+func TestAggregatedSquares(t *testing.T) {
+	t1 := New("", []byte{':'})
+	t1.SetMaxDepth(2)
+	t1.AddKey("user:1:profile", 3)
+	t1.AddKey("user:1:session", 4)
+	t1.AddKey("user:2:profile", 1)
+	t1.AddKey("user:3:profile", 2)
+	t1.AddKey("user:4:profile", 10)
+	id := t1.Expand("user:")["1:"].id
+	if squares := t1.sizeSquareSum(id); squares != 25 {
+		t.Errorf("Expected square sum 25 of keys aggregated by max depth, got %v", squares)
+	}
+
+	t1.Fold(8, 0)
+	other := t1.Expand("user:")[OtherSegment]
+	if squares := t1.sizeSquareSum(other.id); squares != 30 { // 3*3 + 4*4 + 1*1 + 2*2
+		t.Errorf("Expected square sum 30 of folded keys, got %v", squares)
+	}
+	root := t1.Expand("")["user:"]
+	if squares := t1.sizeSquareSum(root.id); squares != 130 {
+		t.Errorf("Expected square sum 130, got %v", squares)
+	}
+
+	// squares are kept by merging and dumping
+	t2 := New("", []byte{':'})
+	t2.AddKey("user:5:profile", 6)
+	if err := t2.Merge(t1); err != nil {
+		t.Fatal(err)
+	}
+	t3 := Load(t2.Dump())
+	for _, tr := range []*Tree{t2, t3} {
+		if squares := tr.sizeSquareSum(rootID); squares != 166 {
+			t.Errorf("Expected square sum 166, got %v", squares)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t1 := New("string", []byte{':'})
+	t1.SetSource("a")
+	t1.AddKey("user:1", 10)
+	t1.AddKey("user:2", 20)
+	t1.MergeSingleChildNode()
+	t2 := New("string", []byte{':'})
+	t2.SetSource("b")
+	t2.AddKey("user:3", 5)
+	t2.AddKey("order:1", 7)
+	t2.MergeSingleChildNode() // order:1 is merged into a single node
+
+	if err := t1.Merge(t2); err != nil {
+		t.Fatal(err)
+	}
+	if size := t1.GetSize("user:"); size != 35 {
+		t.Errorf("Expected size of 35, got %d", size)
+	}
+	if total, num := t1.GetTotalSize(), t1.GetKeyNum(); total != 42 || num != 4 {
+		t.Errorf("Expected total size of 42 and key num 4, got %d %d", total, num)
+	}
+	user := t1.Expand("")["user:"]
+	want := []SourceStat{{Source: "a", KeyNum: 2, Size: 30}, {Source: "b", KeyNum: 1, Size: 5}}
+	if got := user.Sources(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// keys added later are attributed to the source of the tree, and the merged tree can be merged again
+	t1.AddKey("user:4", 1)
+	t3 := New("string", []byte{':'})
+	t3.SetSource("c")
+	t3.AddKey("user:5", 2)
+	if err := t3.Merge(t1); err != nil {
+		t.Fatal(err)
+	}
+	want = []SourceStat{{Source: "c", KeyNum: 1, Size: 2}, {Source: "a", KeyNum: 3, Size: 31}, {Source: "b", KeyNum: 1, Size: 5}}
+	if got := t3.Expand("")["user:"].Sources(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	// folded children keep sources
+	t3.Fold(0, 2)
+	other := t3.Expand("user:")[OtherSegment]
+	if other == nil || other.KeyNum != 5 || !reflect.DeepEqual(other.Sources(), want) {
+		t.Errorf("Expected %s with sources %v, got %+v", OtherSegment, want, other)
+	}
+	// dump and load
+	if got := Load(t3.Dump()).Dump(); !reflect.DeepEqual(got, t3.Dump()) {
+		t.Errorf("Expected same dump after load")
+	}
+
+	if err := t1.Merge(New("string", []byte{'.'})); err == nil {
+		t.Errorf("Expected error merging trees with different separators")
+	}
+}
+
+func TestMergeSampling(t *testing.T) {
+	full := New("", []byte{':'})
+	for i := 0; i < 50; i++ {
+		full.AddKey(fmt.Sprintf("a:%d", i), 10)
+		full.AddKey(fmt.Sprintf("b:%d", i), 20)
+	}
+	// 10% sample of another instance with 1000 keys
+	sampled := New("", []byte{':'})
+	sampled.SetSampling(NewSampling(1000, 100, 0.95))
+	for i := 0; i < 30; i++ {
+		sampled.AddKey(fmt.Sprintf("a:s%d", i), 5)
+	}
+	for i := 0; i < 70; i++ {
+		sampled.AddKey(fmt.Sprintf("b:s%d", i), 1)
+	}
+
+	check := func(name string, tr *Tree) {
+		// every stratum is extrapolated by its own fraction, pooling would scale the full tree by 1100/200
+		e := tr.GetSampling().Estimate(tr.Expand("")["a:"])
+		if e.KeyNum != 350 || e.Size != 2000 {
+			t.Errorf("%s: expected key num 350 and size 2000, got %+v", name, e)
+		}
+		if e.SizeLow < 650 || e.SizeLow >= e.Size || e.SizeHigh <= e.Size {
+			t.Errorf("%s: unexpected size interval %+v", name, e)
+		}
+		if e := tr.Estimate(); e.KeyNum != 1100 || e.Size != 3700 {
+			t.Errorf("%s: expected key num 1100 and size 3700, got %+v", name, e)
+		}
+	}
+	m1, err := Merge(full, sampled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("full and sampled", m1)
+	m2, err := Merge(sampled, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("sampled and full", m2)
+	check("loaded", Load(m1.Dump()))
+	m1.Fold(0, 100)
+	check("folded", m1)
+
+	// strata are kept by merging again and shared by combined trees
+	m3, err := Merge(m2, New("", []byte{':'}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("merged again", m3)
+	c, err := Combine("all", m2, m3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := c.Estimate(); e.KeyNum != 2200 || e.Size != 7400 {
+		t.Errorf("Expected combined key num 2200 and size 7400, got %+v", e)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	t1 := New("", []byte{':'})
+	t1.AddKey("user:1:name", 10)
+	t1.AddKey("user:2:name", 20)
+	frozen := t1.Freeze()
+
+	// writes of either side are not seen by the other
+	t1.AddKey("user:3:name", 5)
+	t1.AddKey("order:1", 7)
+	frozen.MergeSingleChildNode()
+	frozen.Fold(0, 2)
+	if total, num := frozen.GetTotalSize(), frozen.GetKeyNum(); total != 30 || num != 2 {
+		t.Errorf("Expected frozen total size of 30 and key num 2, got %d %d", total, num)
+	}
+	if size := frozen.GetSize("user:"); size != 30 {
+		t.Errorf("Expected frozen size of 30, got %d", size)
+	}
+	if size := t1.GetSize("user:3:"); size != 5 {
+		t.Errorf("Expected size of 5, got %d", size)
+	}
+	if total := t1.GetTotalSize(); total != 42 {
+		t.Errorf("Expected total size of 42, got %d", total)
+	}
+
+	// clone of frozen tree rebuilds edges for adding keys
+	c := t1.Freeze().Clone()
+	c.AddKey("user:1:name", 15)
+	c.AddKey("user:4:name", 1)
+	if size := c.GetSize("user:"); size != 51 {
+		t.Errorf("Expected size of 51, got %d", size)
+	}
+	if size := t1.GetSize("user:"); size != 35 {
+		t.Errorf("Expected size of 35, got %d", size)
+	}
+}
+
+func TestWalkAndQuery(t *testing.T) {
+	t1 := New("string", []byte{':'})
+	t1.AddKey("user:1:profile", 100)
+	t1.AddKey("user:1:session", 50)
+	t1.AddKey("user:2:profile", 3)
+	t1.AddKey("order:10", 8)
+
+	var paths []string
+	err := t1.Walk(func(n *Node, depth int) error {
+		paths = append(paths, fmt.Sprintf("%d %s", depth, n.Path()))
+		if n.Path() == "user:2:" {
+			return SkipChildren
+		}
+		return nil
+	})
+	want := []string{"0 ", "1 order:", "2 order:10", "1 user:", "2 user:1:", "3 user:1:profile", "3 user:1:session", "2 user:2:"}
+	if err != nil || !reflect.DeepEqual(paths, want) {
+		t.Errorf("Expected %q, got %q %v", want, paths, err)
+	}
+
+	paths = paths[:0]
+	for it := t1.Find("user:").Leaves(); it.Next(); {
+		paths = append(paths, it.Node().Path())
+	}
+	want = []string{"user:1:profile", "user:1:session", "user:2:profile"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Expected %q, got %q", want, paths)
+	}
+	if n := t1.Find("user:1:session"); n == nil || n.Parent().Path() != "user:1:" || len(n.Parent().Children()) != 2 {
+		t.Errorf("Unexpected node %+v", n)
+	}
+
+	queries := []struct {
+		pattern    string
+		predicates []Predicate
+		want       []string
+	}{
+		{"user:*:profile", nil, []string{"user:1:profile", "user:2:profile"}},
+		{"user:?:", []Predicate{MinSize(100)}, []string{"user:1:"}},
+		{"*", []Predicate{Leaf(), MaxSize(10)}, []string{"order:10", "user:2:profile"}},
+		{"[a-o]*", []Predicate{MinKeyNum(1), MaxKeyNum(1)}, []string{"order:", "order:10"}},
+		{"user\\:[^1]:*", []Predicate{Leaf()}, []string{"user:2:profile"}},
+	}
+	for _, q := range queries {
+		nodes, err := t1.Query(q.pattern, q.predicates...)
+		var got []string
+		for _, n := range nodes {
+			got = append(got, n.Path())
+		}
+		if err != nil || !reflect.DeepEqual(got, q.want) {
+			t.Errorf("Query %q expected %q, got %q %v", q.pattern, q.want, got, err)
+		}
+	}
+	if _, err := t1.Query("user:[1"); err == nil {
+		t.Errorf("Expected error of unclosed class")
+	}
+}
@@ -0,0 +1,192 @@
+package tree
+
+import (
+	"errors"
+
+	"github.com/iccolo/rma/analyzer/glob"
+)
+
+// SkipChildren is returned by WalkFunc to skip children of the node
+var SkipChildren = errors.New("skip children")
+
+// WalkFunc visit node at depth, root is at depth 0, walking stops at any error except SkipChildren
+type WalkFunc func(n *Node, depth int) error
+
+// Walk visit nodes depth first from root, children are visited in order of segment
+func (t *Tree) Walk(fn WalkFunc) error {
+	return t.walk(rootID, 0, fn)
+}
+
+// Walk visit node and its descendants like Tree.Walk, depth is relative to node
+func (n *Node) Walk(fn WalkFunc) error {
+	return n.tree.walk(n.id, 0, fn)
+}
+
+func (t *Tree) walk(id uint32, depth int, fn WalkFunc) error {
+	if err := fn(t.view(id), depth); err != nil {
+		if err == SkipChildren {
+			return nil
+		}
+		return err
+	}
+	for _, child := range t.sortedChildren(id) {
+		if err := t.walk(child, depth+1, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Root return the view of root, whose segment is the name of tree
+func (t *Tree) Root() *Node {
+	return t.view(rootID)
+}
+
+// Find return the node of key prefix, nil if not exists
+func (t *Tree) Find(keyPrefix string) *Node {
+	if keyPrefix == "" {
+		return t.Root()
+	}
+	if id, ok := t.find(keyPrefix); ok {
+		return t.view(id)
+	}
+	return nil
+}
+
+// Path return the full key prefix of node, which is empty for root
+func (n *Node) Path() string {
+	t := n.tree
+	var segments [][]byte
+	length := 0
+	for id := n.id; id != rootID; id = t.node(id).parent {
+		segment := t.segment(id)
+		segments = append(segments, segment)
+		length += len(segment)
+	}
+	path := make([]byte, 0, length)
+	for i := len(segments) - 1; i >= 0; i-- {
+		path = append(path, segments[i]...)
+	}
+	return string(path)
+}
+
+// Parent return nil for root
+func (n *Node) Parent() *Node {
+	if n.id == rootID {
+		return nil
+	}
+	return n.tree.view(n.tree.node(n.id).parent)
+}
+
+// Children return children sorted by segment
+func (n *Node) Children() []*Node {
+	children := n.tree.sortedChildren(n.id)
+	nodes := make([]*Node, 0, len(children))
+	for _, child := range children {
+		nodes = append(nodes, n.tree.view(child))
+	}
+	return nodes
+}
+
+// IsLeaf return true if node has no child, which is a key, or keys aggregated by max depth or folding
+func (n *Node) IsLeaf() bool {
+	return n.ChildNum == 0
+}
+
+// LeafIterator iterate leaves in the order of Walk
+//
+//	for it := t.Leaves(); it.Next(); {
+//		leaf := it.Node()
+//	}
+type LeafIterator struct {
+	t     *Tree
+	stack [][]uint32 // unvisited siblings of every level
+	leaf  *Node
+}
+
+// Leaves return iterator of leaves under root
+func (t *Tree) Leaves() *LeafIterator {
+	return &LeafIterator{t: t, stack: [][]uint32{{rootID}}}
+}
+
+// Leaves return iterator of leaves under node, which is the only leaf if it has no child
+func (n *Node) Leaves() *LeafIterator {
+	return &LeafIterator{t: n.tree, stack: [][]uint32{{n.id}}}
+}
+
+// Next move to the next leaf, return false if there is no more leaf
+func (it *LeafIterator) Next() bool {
+	for len(it.stack) > 0 {
+		top := len(it.stack) - 1
+		if len(it.stack[top]) == 0 {
+			it.stack = it.stack[:top]
+			continue
+		}
+		id := it.stack[top][0]
+		it.stack[top] = it.stack[top][1:]
+		if it.t.node(id).firstChild == rootID {
+			it.leaf = it.t.view(id)
+			return true
+		}
+		it.stack = append(it.stack, it.t.sortedChildren(id))
+	}
+	it.leaf = nil
+	return false
+}
+
+// Node return the current leaf
+func (it *LeafIterator) Node() *Node {
+	return it.leaf
+}
+
+// Predicate filter nodes of Query
+type Predicate func(n *Node) bool
+
+func MinSize(size int64) Predicate {
+	return func(n *Node) bool { return n.Size >= size }
+}
+
+func MaxSize(size int64) Predicate {
+	return func(n *Node) bool { return n.Size <= size }
+}
+
+func MinKeyNum(num int64) Predicate {
+	return func(n *Node) bool { return n.KeyNum >= num }
+}
+
+func MaxKeyNum(num int64) Predicate {
+	return func(n *Node) bool { return n.KeyNum <= num }
+}
+
+func Leaf() Predicate {
+	return (*Node).IsLeaf
+}
+
+// Query return nodes in the order of Walk whose path match the glob pattern and all predicates, see glob for the
+// syntax, '*' match separators too. Subtrees whose path can't be a prefix of any match are skipped
+func (t *Tree) Query(pattern string, predicates ...Predicate) ([]*Node, error) {
+	if err := glob.Check(pattern); err != nil {
+		return nil, err
+	}
+	var nodes []*Node
+	err := t.Walk(func(n *Node, depth int) error {
+		if depth == 0 {
+			return nil
+		}
+		path := n.Path()
+		if !glob.MatchPrefix(pattern, path) {
+			return SkipChildren
+		}
+		if !glob.Match(pattern, path) {
+			return nil
+		}
+		for _, predicate := range predicates {
+			if !predicate(n) {
+				return nil
+			}
+		}
+		nodes = append(nodes, n)
+		return nil
+	})
+	return nodes, err
+}
@@ -0,0 +1,35 @@
+package analyzer
+
+import (
+	"strconv"
+	"strings"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// serverVersion parse major and minor version from redis_version of INFO server, return 0 if unknown
+func serverVersion(conn redigo.Conn) (int, int) {
+	info, err := redigo.String(conn.Do("INFO", "server"))
+	if err != nil {
+		return 0, 0
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if !strings.HasPrefix(line, "redis_version:") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, "redis_version:")), ".", 3)
+		major, _ := strconv.Atoi(parts[0])
+		minor := 0
+		if len(parts) > 1 {
+			minor, _ = strconv.Atoi(parts[1])
+		}
+		return major, minor
+	}
+	return 0, 0
+}
+
+// versionAtLeast return true if server version is not lower than major.minor
+func versionAtLeast(conn redigo.Conn, major, minor int) bool {
+	serverMajor, serverMinor := serverVersion(conn)
+	return serverMajor > major || serverMajor == major && serverMinor >= minor
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/gui/server/analyze"
+)
+
+// Register handle http apis on mux, which are served by h, for the gui server and the serve command
+func Register(mux *http.ServeMux, h analyze.Handler) {
+	s := &server{h: h}
+	mux.HandleFunc("/api/rma/get_instance_list", s.GetInstanceList)
+	mux.HandleFunc("/api/rma/start_analyze", s.StartAnalyze)
+	mux.HandleFunc("/api/rma/get_key_type", s.GetKeyType)
+	mux.HandleFunc("/api/rma/expand", s.Expand)
+	mux.HandleFunc("/api/rma/get_key_info", s.GetKeyInfo)
+	mux.HandleFunc("/api/rma/get_progress", s.GetProgress)
+}
+
+type server struct {
+	h analyze.Handler
+}
+
+func (s *server) GetInstanceList(response http.ResponseWriter, request *http.Request) {
+	if intercept(response, request, nil) {
+		return
+	}
+	list := s.h.GetInstanceList()
+	out, _ := json.Marshal(list)
+	log.Println(string(out))
+	response.Write(out)
+}
+
+func (s *server) StartAnalyze(response http.ResponseWriter, request *http.Request) {
+	a := &analyzer.Analyzer{}
+	if intercept(response, request, a) {
+		return
+	}
+	log.Printf("%+v\n", a)
+	// separators are detected from sample keys if Separators is analyzer.SeparatorsAuto
+	if a.Separators == "" && a.Rules == "" && !a.AutoSeparators {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if _, err := escape.Parse(a.Escape); err != nil {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.h.StartAnalyze(a)
+}
+
+func (s *server) GetKeyType(response http.ResponseWriter, request *http.Request) {
+	type In struct {
+		Host string `json:"host"`
+	}
+	in := &In{}
+	if intercept(response, request, in) {
+		return
+	}
+	keyTypes, err := s.h.GetKeyTypes(in.Host)
+	if err != nil {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	out, _ := json.Marshal(keyTypes)
+	log.Println(string(out))
+	response.Write(out)
+}
+
+func (s *server) GetProgress(response http.ResponseWriter, request *http.Request) {
+	type In struct {
+		Host string `json:"host"`
+	}
+	in := &In{}
+	if intercept(response, request, in) {
+		return
+	}
+	progress, err := s.h.GetProgress(in.Host)
+	if err != nil {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	out, _ := json.Marshal(progress)
+	response.Write(out)
+}
+
+func (s *server) Expand(response http.ResponseWriter, request *http.Request) {
+	type In struct {
+		Host      string `json:"host"`
+		KeyType   string `json:"key_type"`
+		KeyPrefix string `json:"key_prefix"`
+		NumLimit  int64  `json:"num_limit"`
+		SortVar   int32  `json:"sort_var"`
+	}
+	in := &In{}
+	if intercept(response, request, in) {
+		return
+	}
+	nodeList, err := s.h.Expand(in.Host, in.KeyType, in.KeyPrefix, in.NumLimit, analyze.SortVar(in.SortVar))
+	if err != nil {
+		log.Printf("Expand err:%v, in:%+v", err, in)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	out, _ := json.Marshal(nodeList)
+	log.Println("out:", string(out))
+	response.Write(out)
+	return
+}
+
+func (s *server) GetKeyInfo(response http.ResponseWriter, request *http.Request) {
+	type In struct {
+		Host string `json:"host"`
+		Key  string `json:"key"`
+	}
+	in := &In{}
+	if intercept(response, request, in) {
+		return
+	}
+	keyInfo, err := s.h.GetKeyInfo(in.Host, in.Key, 10)
+	if err != nil {
+		log.Printf("GetKeyInfo err:%v, in:%+v", err, in)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	out, _ := json.Marshal(keyInfo)
+	log.Println("out:", string(out))
+	response.Write(out)
+	return
+}
+
+// intercept unmarshal body and return if intercept process logic
+func intercept(response http.ResponseWriter, request *http.Request, body interface{}) bool {
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+	response.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization,X-CSRF-Token")
+	if request.Method == "OPTIONS" {
+		return true
+	}
+	binaryBody, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		log.Printf("ReadAll request.Body err:%v\n", err)
+		response.WriteHeader(http.StatusBadRequest)
+		return true
+	}
+	if body != nil {
+		log.Println("req body:", string(binaryBody))
+		if err = json.Unmarshal(binaryBody, body); err != nil {
+			log.Printf("json.Unmarshal request.Body:%v, err:%v\n", string(binaryBody), err)
+			response.WriteHeader(http.StatusBadRequest)
+			return true
+		}
+	}
+	return false
+}
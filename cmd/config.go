@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// config is the file of -config in yaml, or in toml or json by extension .toml or .json, for example:
+//
+//	defaults:
+//	  separators: ":"
+//	  exclude: ["tmp:*"]
+//	instances:
+//	  cache:
+//	    host: 10.0.0.1
+//	    password: env:CACHE_PASSWORD
+//	output:
+//	  format: text
+//	  top: 20
+//
+// Settings of defaults and instances are fields of analyzer.Analyzer named like its json.
+// A json file without these sections is the json of analyzer.Analyzer, which was the file of -conf
+type config struct {
+	Defaults  map[string]interface{}            `yaml:"defaults" toml:"defaults" json:"defaults"`
+	Instances map[string]map[string]interface{} `yaml:"instances" toml:"instances" json:"instances"`
+	Output    outputConfig                      `yaml:"output" toml:"output" json:"output"`
+}
+
+type outputConfig struct {
+	Format     string `yaml:"format" toml:"format" json:"format"`
+	Escape     string `yaml:"escape" toml:"escape" json:"escape"`
+	Top        int    `yaml:"top" toml:"top" json:"top"`
+	PrintDepth int    `yaml:"print_depth" toml:"print_depth" json:"print_depth"`
+	MinSize    int64  `yaml:"min_size" toml:"min_size" json:"min_size"`
+	Bar        *int   `yaml:"bar" toml:"bar" json:"bar"`
+	Save       string `yaml:"save" toml:"save" json:"save"`
+	TUI        bool   `yaml:"tui" toml:"tui" json:"tui"`
+}
+
+// settings which may be references of credentials
+var credentialKeys = []string{"password", "backend_password"}
+
+func isCredentialKey(key string) bool {
+	for _, credentialKey := range credentialKeys {
+		if strings.EqualFold(key, credentialKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadConfig(file string) (*config, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".toml":
+		err = toml.Unmarshal(content, c)
+	case ".json":
+		var settings map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber() // keep big integers exact
+		if err = decoder.Decode(&settings); err != nil {
+			return nil, err
+		}
+		_, hasDefaults := settings["defaults"]
+		_, hasInstances := settings["instances"]
+		_, hasOutput := settings["output"]
+		if !hasDefaults && !hasInstances && !hasOutput {
+			c.Defaults = settings
+			return c, nil
+		}
+		err = json.Unmarshal(content, c)
+	default:
+		err = yaml.Unmarshal(content, c)
+	}
+	return c, err
+}
+
+// analyzerJSON return settings of instance over defaults as json of analyzer.Analyzer,
+// instance can be empty if there is at most one instance
+func (c *config) analyzerJSON(instance string) ([]byte, error) {
+	if instance == "" && len(c.Instances) > 1 {
+		return nil, fmt.Errorf("choose an instance by -instance from %s", strings.Join(c.instanceNames(), ", "))
+	}
+	if instance == "" {
+		for name := range c.Instances {
+			instance = name
+		}
+	}
+	settings, ok := c.Instances[instance]
+	if instance != "" && !ok {
+		return nil, fmt.Errorf("instance %q not exists", instance)
+	}
+	merged := make(map[string]interface{})
+	for key, value := range c.Defaults {
+		merged[key] = value
+	}
+	for key, value := range settings {
+		merged[key] = value
+	}
+	// keys are matched case-insensitively like json fields of analyzer.Analyzer
+	for key, value := range merged {
+		str, ok := value.(string)
+		switch {
+		case !ok:
+		case isCredentialKey(key):
+			secret, err := resolveCredential(str)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			merged[key] = secret
+		case strings.EqualFold(key, "pause"):
+			ms, err := parseMs(str)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			merged[key] = ms
+		}
+	}
+	return json.Marshal(merged)
+}
+
+func (c *config) instanceNames() []string {
+	names := make([]string, 0, len(c.Instances))
+	for name := range c.Instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveCredential read credential referenced by env:NAME or file:PATH, other values are the credential itself
+func resolveCredential(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, "file:"):
+		content, err := ioutil.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(content)), nil
+	default:
+		return ref, nil
+	}
+}
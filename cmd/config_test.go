@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/iccolo/rma/analyzer"
+)
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secret, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("RMA_TEST_PASSWORD", "from-env")
+	defer os.Unsetenv("RMA_TEST_PASSWORD")
+	files := map[string]string{
+		"rma.yaml": `
+defaults:
+  separators: "|"
+  exclude: ["tmp:*"]
+  pause: 10ms
+instances:
+  cache:
+    host: 10.0.0.1
+    port: 6380
+    password: env:RMA_TEST_PASSWORD
+  session:
+    host: 10.0.0.2
+    password: file:` + secret + `
+output:
+  format: json
+  top: 5
+`,
+		"rma.json": `{
+  "defaults": {"separators": "|", "exclude": ["tmp:*"], "Pause": "10ms"},
+  "instances": {
+    "cache": {"host": "10.0.0.1", "port": 6380, "PASSWORD": "env:RMA_TEST_PASSWORD"},
+    "session": {"host": "10.0.0.2", "Password": "file:` + secret + `"}
+  },
+  "output": {"format": "json", "top": 5}
+}`,
+		"rma.toml": `
+[defaults]
+separators = "|"
+exclude = ["tmp:*"]
+pause = "10ms"
+
+[instances.cache]
+host = "10.0.0.1"
+port = 6380
+password = "env:RMA_TEST_PASSWORD"
+
+[instances.session]
+host = "10.0.0.2"
+password = "file:` + secret + `"
+
+[output]
+format = "json"
+top = 5
+`,
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+		af := newAnalyzerFlags(fs)
+		o := newOutputFlags(fs)
+		if err := fs.Parse([]string{"-config", file, "-instance", "cache", "-top", "3"}); err != nil {
+			t.Fatal(err)
+		}
+		a := af.analyzer(o)
+		if a.Host != "10.0.0.1" || a.Port != 6380 || a.Password != "from-env" || a.Separators != "|" ||
+			len(a.Exclude) != 1 || a.Pause != 10 || a.Count != 10000 {
+			t.Errorf("%s: unexpected analyzer %+v", name, a)
+		}
+		if o.format != "json" || o.top != 3 {
+			t.Errorf("%s: unexpected output %+v", name, o)
+		}
+
+		c, err := loadConfig(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.analyzerJSON(""); err == nil {
+			t.Errorf("%s: expected error without instance", name)
+		}
+		content, err := c.analyzerJSON("session")
+		if err != nil {
+			t.Fatal(err)
+		}
+		session := &analyzer.Analyzer{}
+		if err = json.Unmarshal(content, session); err != nil || session.Password != "s3cret" {
+			t.Errorf("%s: unexpected session %+v, err:%v", name, session, err)
+		}
+	}
+}
+
+func TestJSONConf(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rma.json")
+	if err := ioutil.WriteFile(file, []byte(`{"Host": "10.0.0.3", "separators": "/", "count": 5, "limit": 9007199254740993}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	af := newAnalyzerFlags(fs)
+	if err := fs.Parse([]string{"-conf", file, "-count", "7", "-backends", "10.0.0.4:6379,10.0.0.5:6379"}); err != nil {
+		t.Fatal(err)
+	}
+	a := af.analyzer(nil)
+	if a.Host != "10.0.0.3" || a.Separators != "/" || a.Count != 7 || a.Limit != 9007199254740993 || len(a.Backends) != 2 {
+		t.Errorf("Unexpected analyzer %+v", a)
+	}
+}
+
+// every flag of analyzer is bound to a field of it, so that it overrides config files if it is set explicitly
+func TestAnalyzerFlagsBound(t *testing.T) {
+	fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	af := newAnalyzerFlags(fs)
+	bound := reflect.ValueOf(&af.a).Elem()
+	fields := make(map[uintptr]bool)
+	for i := 0; i < bound.NumField(); i++ {
+		fields[bound.Field(i).UnsafeAddr()] = true
+	}
+	fs.VisitAll(func(fl *flag.Flag) {
+		if fl.Name != "config" && fl.Name != "conf" && fl.Name != "instance" && !fields[reflect.ValueOf(fl.Value).Pointer()] {
+			t.Errorf("Flag -%s is not bound to a field of analyzer", fl.Name)
+		}
+	})
+}
+
+func TestMsFlag(t *testing.T) {
+	var pause time.Duration
+	f := (*msFlag)(&pause)
+	for value, want := range map[string]time.Duration{"1000": 1000, "1s": 1000, "250ms": 250} {
+		if err := f.Set(value); err != nil || pause != want {
+			t.Errorf("Expected %d for %s, got %d, err:%v", want, value, pause, err)
+		}
+	}
+	_ = f.Set("250ms")
+	if f.String() != "250ms" {
+		t.Errorf("Unexpected string %s", f.String())
+	}
+	if err := f.Set("soon"); err == nil {
+		t.Errorf("Expected error")
+	}
+}
+
+func TestClusterFlag(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		want bool
+	}{
+		{nil, true}, // true by default like -c of the old cli
+		{[]string{"-cluster=false"}, false},
+		{[]string{"-c=false"}, false}, // deprecated alias
+		{[]string{"-c=false", "-cluster"}, true},
+	} {
+		fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+		af := newAnalyzerFlags(fs)
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+		if a := af.analyzer(nil); a.Cluster != c.want {
+			t.Errorf("Expected cluster %v by %q, got %v", c.want, c.args, a.Cluster)
+		}
+	}
+}
+
+func TestFormatGiven(t *testing.T) {
+	for _, c := range []struct {
+		args   []string
+		config *outputConfig
+		want   bool
+	}{
+		{nil, nil, false},
+		{[]string{"-o", "text"}, nil, true},
+		{nil, &outputConfig{Format: "json"}, true},
+		{nil, &outputConfig{Top: 10}, false},
+	} {
+		fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+		o := newOutputFlags(fs)
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+		if c.config != nil {
+			o.apply(c.config)
+		}
+		if got := o.formatGiven(); got != c.want {
+			t.Errorf("Expected format given %v by %q and %+v, got %v", c.want, c.args, c.config, got)
+		}
+	}
+}
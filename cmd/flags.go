@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/report"
+)
+
+// analyzerFlags bind flags of subcommands analyzing an instance to an analyzer
+type analyzerFlags struct {
+	fs       *flag.FlagSet
+	a        analyzer.Analyzer
+	config   string
+	instance string
+}
+
+func newAnalyzerFlags(fs *flag.FlagSet) *analyzerFlags {
+	f := &analyzerFlags{fs: fs}
+	a := &f.a
+	a.Pause = 1000
+	fs.StringVar(&a.Host, "h", "127.0.0.1", "host")
+	fs.UintVar(&a.Port, "p", 6379, "port")
+	fs.StringVar(&a.Password, "a", "", "password")
+	fs.UintVar(&a.Count, "count", 10000, "count")
+	fs.Uint64Var(&a.Limit, "l", 100000, "max keys to read, split among backends by DBSIZE")
+	fs.StringVar(&a.Match, "m", "*", "match")
+	fs.Var((*stringsFlag)(&a.Include), "include", "only analyze keys match the pattern, glob or regexp with prefix \""+analyzer.RegexpPrefix+"\", repeatable")
+	fs.Var((*stringsFlag)(&a.Exclude), "exclude", "skip keys match the pattern, glob or regexp with prefix \""+analyzer.RegexpPrefix+"\", repeatable")
+	fs.StringVar(&a.Types, "t", "", "types")
+	fs.StringVar(&a.Separators, "s", ":", "separators, \"auto\" to detect from sample keys")
+	fs.StringVar(&a.Rules, "separator-rules", "", "separator rules by depth like \"0-2=: .;3-=:\", override -s")
+	fs.IntVar(&a.MaxDepth, "max-depth", 0, "aggregate keys deeper than it into their prefix, 0 for no limit")
+	fs.Int64Var(&a.FoldSize, "fold-size", 0, "fold children smaller than it into (other)")
+	fs.Int64Var(&a.FoldKeyNum, "fold-keys", 0, "fold children with less keys than it into (other)")
+	// true by default like -c of the old cli, otherwise the default size mode would silently change to memory-usage
+	fs.BoolVar(&a.Cluster, "cluster", true, "redis cluster, which decides estimate size mode if -size-mode is empty, -cluster=false for memory-usage")
+	fs.BoolVar(&a.Cluster, "c", true, "deprecated alias of -cluster")
+	fs.StringVar(&a.SizeMode, "size-mode", "", "size mode, one of memory-usage, estimate, exact, encoding-aware, decided by -cluster if empty")
+	fs.IntVar(&a.MemorySamples, "memory-samples", 0, "SAMPLES of MEMORY USAGE, redis default if 0, all elements if negative")
+	fs.UintVar(&a.ElementSample, "element-sample", analyzer.DefaultElementSample, "elements sampled per collection to estimate its size")
+	fs.UintVar(&a.ExactThreshold, "exact-threshold", 0, "read all elements of collections not longer than it to get exact size")
+	fs.StringVar(&a.Coefficients, "coefficients", "", "file of coefficients scaling estimated size, fitted by calibrate")
+	fs.Var((*msFlag)(&a.Pause), "pause", "pause between batches of keys, like 100ms, integers are in ms")
+	fs.Uint64Var(&a.Sample, "sample", 0, "sample keys by RANDOMKEY instead of SCAN and extrapolate totals by DBSIZE, 0 to scan all keys")
+	fs.Float64Var(&a.Confidence, "confidence", 0.95, "confidence level of sampling estimates")
+	fs.BoolVar(&a.TTL, "ttl", false, "collect TTL of keys for TTL stats of html output")
+	fs.IntVar(&a.BigKeyNum, "big-keys", analyzer.DefaultBigKeyNum, "biggest keys kept for every type, none if negative")
+	fs.Var((*commaFlag)(&a.Backends), "backends", "redis servers behind proxy to scan directly, host:port separated by comma")
+	fs.StringVar(&a.BackendPassword, "backend-password", "", "password of backends, -a by default")
+	fs.StringVar(&a.Discover, "discover", "", "discover backends from proxy admin, codis://dashboard, twemproxy://stats or envoy://admin/cluster")
+	fs.StringVar(&a.KeyFile, "key-file", "", "read keys from file instead of SCAN, one key per line")
+	fs.StringVar(&f.config, "config", "", "config file of instances and output, json, toml or yaml by extension, flags set explicitly override it")
+	fs.StringVar(&f.config, "conf", "", "deprecated alias of -config")
+	fs.StringVar(&f.instance, "instance", "", "instance of -config to analyze, the only one by default")
+	return f
+}
+
+// analyzer return the analyzer of flags and config files, o is filled by output settings of -config
+func (f *analyzerFlags) analyzer(o *outputFlags) *analyzer.Analyzer {
+	a := f.a
+	if f.config != "" {
+		c, err := loadConfig(f.config)
+		if err != nil {
+			log.Fatalf("load config %s err:%v", f.config, err)
+		}
+		content, err := c.analyzerJSON(f.instance)
+		if err != nil {
+			log.Fatalf("config %s err:%v", f.config, err)
+		}
+		if err = json.Unmarshal(content, &a); err != nil {
+			log.Fatalf("parse instance of config %s err:%v", f.config, err)
+		}
+		if o != nil {
+			o.apply(&c.Output)
+		}
+	}
+	f.override(&a)
+	escapeSet := false
+	f.fs.Visit(func(fl *flag.Flag) {
+		escapeSet = escapeSet || fl.Name == "escape"
+		switch fl.Name {
+		case "c":
+			log.Println("-c is deprecated, use -cluster")
+		case "conf":
+			log.Println("-conf is deprecated, use -config")
+		}
+	})
+	if o != nil {
+		// escape of json conf is kept unless -escape is set
+		if a.Escape != "" && !escapeSet {
+			o.escape = a.Escape
+		}
+		a.Escape = o.escape
+	}
+	return &a
+}
+
+// override set fields of a bound to flags set explicitly to values of the flags, which override config files.
+// Flags are bound to fields of f.a, so the field of a flag is the one its value points to
+func (f *analyzerFlags) override(a *analyzer.Analyzer) {
+	fromFlags := reflect.ValueOf(&f.a).Elem()
+	fields := make(map[uintptr]int, fromFlags.NumField())
+	for i := 0; i < fromFlags.NumField(); i++ {
+		fields[fromFlags.Field(i).UnsafeAddr()] = i
+	}
+	to := reflect.ValueOf(a).Elem()
+	f.fs.Visit(func(fl *flag.Flag) {
+		value := reflect.ValueOf(fl.Value)
+		if value.Kind() != reflect.Ptr {
+			return
+		}
+		if i, ok := fields[value.Pointer()]; ok {
+			to.Field(i).Set(fromFlags.Field(i))
+		}
+	})
+}
+
+// outputFlags decide how results are written
+type outputFlags struct {
+	fs         *flag.FlagSet
+	format     string
+	escape     string
+	top        int
+	printDepth int
+	minSize    int64
+	bar        int
+	save       string
+	tui        bool
+	formatSet  bool // format is set by config
+}
+
+func newOutputFlags(fs *flag.FlagSet) *outputFlags {
+	o := newTextFlags(fs, 0)
+	fs.StringVar(&o.format, "o", "text", "output format, one of text, json, jsonl, folded, flamegraph, pprof, html")
+	fs.IntVar(&o.printDepth, "print-depth", 0, "prefixes deeper than it are not printed by text output, 0 for no limit")
+	fs.IntVar(&o.bar, "bar", report.DefaultBarWidth, "width of bars of text output, 0 for no bar")
+	fs.StringVar(&o.save, "save", "", "save snapshot of the result to the file, which can be read by report, diff and query")
+	fs.BoolVar(&o.tui, "tui", false, "browse the result in an interactive terminal ui, which is updated live while analyzing")
+	return o
+}
+
+// newTextFlags bind flags shared by commands printing prefixes
+func newTextFlags(fs *flag.FlagSet, top int) *outputFlags {
+	o := &outputFlags{fs: fs}
+	fs.StringVar(&o.escape, "escape", "auto", "escape keys in outputs, one of auto, raw, quote, hex, base64")
+	fs.IntVar(&o.top, "top", top, "biggest prefixes printed, per prefix by text output, 0 for all")
+	fs.Int64Var(&o.minSize, "min-size", 0, "prefixes smaller than it are not printed")
+	return o
+}
+
+// apply output settings of config to flags not set explicitly
+func (o *outputFlags) apply(c *outputConfig) {
+	set := make(map[string]bool)
+	o.fs.Visit(func(fl *flag.Flag) {
+		set[fl.Name] = true
+	})
+	if c.Format != "" && !set["o"] {
+		o.format = c.Format
+		o.formatSet = true
+	}
+	if c.Escape != "" && !set["escape"] {
+		o.escape = c.Escape
+	}
+	if c.Top != 0 && !set["top"] {
+		o.top = c.Top
+	}
+	if c.PrintDepth != 0 && !set["print-depth"] {
+		o.printDepth = c.PrintDepth
+	}
+	if c.MinSize != 0 && !set["min-size"] {
+		o.minSize = c.MinSize
+	}
+	if c.Bar != nil && !set["bar"] {
+		o.bar = *c.Bar
+	}
+	if c.Save != "" && !set["save"] {
+		o.save = c.Save
+	}
+	if c.TUI && !set["tui"] {
+		o.tui = true
+	}
+}
+
+// formatGiven tell whether the format is given by -o or config rather than the default
+func (o *outputFlags) formatGiven() bool {
+	given := o.formatSet
+	o.fs.Visit(func(fl *flag.Flag) {
+		given = given || fl.Name == "o"
+	})
+	return given
+}
+
+func (o *outputFlags) mode() escape.Mode {
+	mode, err := escape.Parse(o.escape)
+	if err != nil {
+		log.Fatalf("parse escape mode err:%v", err)
+	}
+	return mode
+}
+
+func (o *outputFlags) textOptions() report.TextOptions {
+	opts := report.TextOptions{Escape: o.mode(), TopN: o.top, MaxDepth: o.printDepth, MinSize: o.minSize, BarWidth: o.bar}
+	if opts.BarWidth == 0 {
+		opts.BarWidth = -1
+	}
+	return opts
+}
+
+// stringsFlag collect a repeatable string flag
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// commaFlag is a list of strings separated by comma
+type commaFlag []string
+
+func (c *commaFlag) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *commaFlag) Set(value string) error {
+	*c = strings.Split(value, ",")
+	return nil
+}
+
+// msFlag is a duration counted in ms like analyzer.Analyzer.Pause, which is set by a duration or an integer of ms
+type msFlag time.Duration
+
+func (m *msFlag) String() string {
+	return (time.Duration(*m) * time.Millisecond).String()
+}
+
+func (m *msFlag) Set(value string) error {
+	ms, err := parseMs(value)
+	*m = msFlag(ms)
+	return err
+}
+
+// parseMs parse a duration like 100ms or an integer of ms, return the number of ms
+func parseMs(value string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(ms), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d / time.Millisecond, nil
+}
@@ -2,51 +2,294 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/report"
+	"github.com/iccolo/rma/analyzer/tree"
+	"github.com/iccolo/rma/api"
+	"github.com/iccolo/rma/gui/server/analyze"
 )
 
-var (
-	host       string
-	port       uint
-	password   string
-	count      uint
-	limit      uint64
-	match      string
-	types      string
-	separators string
-	cluster    bool
-	pause      time.Duration
-)
-
-func init() {
-	flag.StringVar(&host, "h", "127.0.0.1", "host")
-	flag.UintVar(&port, "p", 6379, "port")
-	flag.StringVar(&password, "a", "", "password")
-	flag.UintVar(&count, "count", 10000, "count")
-	flag.Uint64Var(&limit, "l", 100000, "limit")
-	flag.StringVar(&match, "m", "*", "match")
-	flag.StringVar(&types, "t", "", "types")
-	flag.StringVar(&separators, "s", ":", "separators")
-	flag.BoolVar(&cluster, "c", true, "cluster")
-	flag.DurationVar(&pause, "pause", 1000, "pause")
+// subcommands, analyze is run if none is given
+var commands = []struct {
+	name  string
+	usage string
+	run   func(args []string)
+}{
+	{"analyze", "analyze an instance and write the result", runAnalyze},
+	{"report", "write the result of saved snapshots, which are merged if more than one", runReport},
+	{"diff", "compare two saved snapshots", runDiff},
+	{"serve", "start the api server of gui", runServe},
+	{"calibrate", "compare estimated size with MEMORY USAGE and fit coefficients", runCalibrate},
+	{"query", "print prefixes of saved snapshots matching a glob pattern", runQuery},
 }
 
 func main() {
-	flag.Parse()
-	a := &analyzer.Analyzer{
-		Host:       host,
-		Port:       port,
-		Password:   password,
-		Count:      count,
-		Limit:      limit,
-		Match:      match,
-		Types:      types,
-		Separators: separators,
-		Cluster:    cluster,
-		Pause:      pause,
-	}
-	tree := a.Run()
-	tree.Print()
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		for _, c := range commands {
+			if c.name == args[0] {
+				c.run(args[1:])
+				return
+			}
+		}
+		if args[0] != "help" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		}
+		usage()
+		os.Exit(2)
+	}
+	runAnalyze(args)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags] [args]\n\ncommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun \"%s <command> -help\" for flags of command\n", os.Args[0])
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s %s [flags] %s\n", os.Args[0], name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func runAnalyze(args []string) {
+	fs := newFlagSet("analyze", "")
+	af := newAnalyzerFlags(fs)
+	o := newOutputFlags(fs)
+	detect := fs.Bool("detect", false, "only print separators detected from sample keys")
+	progress := fs.Bool("progress", true, "show progress on stderr, a bar on terminal or a log line every 10s otherwise")
+	_ = fs.Parse(args)
+	a := af.analyzer(o)
+	if *detect {
+		fmt.Printf("detected separators: %q\n", a.DetectSeparators())
+		return
+	}
+	if *progress && !o.tui { // the tui shows progress itself
+		showProgress(a)
+	}
+	stopOnSignal(a)
+	start := time.Now()
+	tree, wg := a.AsyncRun()
+	write := true
+	if o.tui {
+		runTUI(a, tree.Snapshot, a.EscapeMode())
+		// quitting the tui stops analyzing, keys read are written only if -o is given, and saved by -save
+		a.Stop()
+		write = o.formatGiven()
+	}
+	wg.Wait()
+	log.SetOutput(os.Stderr)
+	snapshot := tree.Snapshot()
+	meta := report.NewMeta(a)
+	meta.StartTime = &start
+	if write {
+		writeOutput(o, snapshot, meta, a.FilteredKeyNum())
+	}
+	if n := a.FilteredKeyNum(); n > 0 {
+		log.Printf("filtered out %d keys by include and exclude patterns\n", n)
+	}
+	saveSnapshot(o.save, snapshot)
+	if len(snapshot.Cursors) > 0 {
+		os.Exit(130)
+	}
+}
+
+// stopOnSignal stop a on SIGINT or SIGTERM, so that keys read are still analyzed and written as an incomplete
+// result, a second signal exits at once
+func stopOnSignal(a *analyzer.Analyzer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("%v received, finishing with keys read, send again to exit at once\n", sig)
+		a.Stop()
+		<-signals
+		os.Exit(130)
+	}()
+}
+
+// writeOutput write snapshot to stdout in the format of -o
+func writeOutput(o *outputFlags, snapshot *analyzer.Snapshot, meta report.Meta, filteredKeyNum int64) {
+	mode := o.mode()
+	meta.Escape = string(mode)
+	var err error
+	switch o.format {
+	case "text":
+		err = report.WriteText(os.Stdout, snapshot, o.textOptions())
+	case "json":
+		err = report.New(snapshot, meta, filteredKeyNum).WriteJSON(os.Stdout)
+	case "jsonl":
+		err = report.WriteJSONL(os.Stdout, snapshot, mode)
+	case "folded":
+		err = report.WriteFolded(os.Stdout, snapshot, mode)
+	case "flamegraph":
+		err = report.WriteFlameGraph(os.Stdout, snapshot, mode, "Redis memory of "+meta.Source)
+	case "pprof":
+		err = report.WritePprof(os.Stdout, snapshot, mode, "Redis memory of "+meta.Source)
+	case "html":
+		err = report.WriteHTML(os.Stdout, snapshot, meta, filteredKeyNum)
+	default:
+		log.Fatalf("unknown output format %q", o.format)
+	}
+	if err != nil {
+		log.Fatalf("write %s output err:%v", o.format, err)
+	}
+}
+
+func runReport(args []string) {
+	fs := newFlagSet("report", "snapshot...")
+	o := newOutputFlags(fs)
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	merged := loadSnapshots(fs.Args())
+	if o.tui {
+		runTUI(nil, func() *analyzer.Snapshot { return merged }, o.mode())
+	} else {
+		writeOutput(o, merged, report.Meta{Source: strings.Join(fs.Args(), ",")}, 0)
+	}
+	saveSnapshot(o.save, merged)
+}
+
+// loadSnapshots load snapshot files and merge them, keys are attributed to their sources
+func loadSnapshots(files []string) *analyzer.Snapshot {
+	var merged *analyzer.Snapshot
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatalf("open snapshot %s err:%v", file, err)
+		}
+		snapshot, err := analyzer.LoadSnapshot(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("load snapshot %s err:%v", file, err)
+		}
+		if merged == nil {
+			merged = snapshot
+		} else if merged, err = merged.Merge(snapshot); err != nil {
+			log.Fatalf("merge snapshot %s err:%v", file, err)
+		}
+	}
+	return merged
+}
+
+func saveSnapshot(file string, snapshot *analyzer.Snapshot) {
+	if file == "" {
+		return
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		log.Fatalf("create snapshot %s err:%v", file, err)
+	}
+	defer f.Close()
+	if err = snapshot.Save(f); err != nil {
+		log.Fatalf("save snapshot to %s err:%v", file, err)
+	}
+	log.Printf("snapshot saved to %s\n", file)
+}
+
+func runDiff(args []string) {
+	fs := newFlagSet("diff", "old-snapshot new-snapshot")
+	o := newTextFlags(fs, 20)
+	fs.IntVar(&o.printDepth, "print-depth", 0, "prefixes deeper than it are not printed, 0 for no limit")
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	before, after := loadSnapshots(fs.Args()[:1]), loadSnapshots(fs.Args()[1:])
+	if err := report.WriteDiff(os.Stdout, before, after, o.textOptions()); err != nil {
+		log.Fatalf("write diff err:%v", err)
+	}
+}
+
+func runServe(args []string) {
+	fs := newFlagSet("serve", "")
+	addr := fs.String("addr", ":8090", "address to listen")
+	_ = fs.Parse(args)
+	api.Register(http.DefaultServeMux, analyze.NewHandler())
+	log.Printf("serve on %s\n", *addr)
+	if err := http.ListenAndServe(*addr, nil); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runCalibrate(args []string) {
+	fs := newFlagSet("calibrate", "")
+	af := newAnalyzerFlags(fs)
+	num := fs.Int("n", 100, "compare at most n keys per type and encoding")
+	saveCoefs := fs.String("save-coefficients", "", "save fitted coefficients to the file, which is read by -coefficients")
+	_ = fs.Parse(args)
+	a := af.analyzer(nil)
+	c := a.Calibrate(*num)
+	c.Print()
+	if *saveCoefs == "" {
+		return
+	}
+	if err := c.Coefficients().Save(*saveCoefs); err != nil {
+		log.Fatalf("save coefficients to %s err:%v", *saveCoefs, err)
+	}
+	log.Printf("coefficients saved to %s\n", *saveCoefs)
+}
+
+func runQuery(args []string) {
+	fs := newFlagSet("query", "pattern snapshot...")
+	o := newTextFlags(fs, 0)
+	typeStr := fs.String("type", analyzer.KeyTypeAllStr, "key type to query, all types by default")
+	maxSize := fs.Int64("max-size", 0, "only prefixes not bigger than it, 0 for no limit")
+	minKeys := fs.Int64("min-keys", 0, "only prefixes with at least n keys")
+	maxKeys := fs.Int64("max-keys", 0, "only prefixes with at most n keys, 0 for no limit")
+	leaf := fs.Bool("leaf", false, "only leaves, which are keys or keys aggregated by depth or folding")
+	_ = fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	keyT, ok := analyzer.ParseKeyType(*typeStr)
+	if !ok {
+		log.Fatalf("unknown key type %q", *typeStr)
+	}
+	predicates := []tree.Predicate{tree.MinSize(o.minSize), tree.MinKeyNum(*minKeys)}
+	if *maxSize > 0 {
+		predicates = append(predicates, tree.MaxSize(*maxSize))
+	}
+	if *maxKeys > 0 {
+		predicates = append(predicates, tree.MaxKeyNum(*maxKeys))
+	}
+	if *leaf {
+		predicates = append(predicates, tree.Leaf())
+	}
+	snapshot := loadSnapshots(fs.Args()[1:])
+	nodes, err := snapshot.Tree(keyT).Query(fs.Arg(0), predicates...)
+	if err != nil {
+		log.Fatalf("query err:%v", err)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Size > nodes[j].Size
+	})
+	if o.top > 0 && len(nodes) > o.top {
+		nodes = nodes[:o.top]
+	}
+	mode := o.mode()
+	for _, n := range nodes {
+		fmt.Printf("%10s %10d  %s\n", report.FormatBytes(n.Size), n.KeyNum, mode.Escape(n.Path()))
+	}
 }
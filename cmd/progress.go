@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/report"
+)
+
+const progressBarWidth = 30
+
+// showProgress show progress of a on stderr while running, a bar redrawn in place on terminal,
+// or a log line every 10s otherwise
+func showProgress(a *analyzer.Analyzer) {
+	if !isTerminal(os.Stderr) {
+		a.OnProgress(10*time.Second, func(p analyzer.Progress) {
+			if !p.Done {
+				log.Printf("progress: %s\n", progressText(p))
+			}
+		})
+		return
+	}
+	b := &progressBar{w: os.Stderr}
+	log.SetOutput(b)
+	a.OnProgress(200*time.Millisecond, b.update)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar keep a bar on the last line of terminal, log output is written above it
+type progressBar struct {
+	mu   sync.Mutex
+	w    io.Writer
+	line string // the bar drawn, empty if done
+}
+
+func (b *progressBar) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fmt.Fprint(b.w, "\r\x1b[K")
+	n, err := b.w.Write(p)
+	fmt.Fprint(b.w, b.line)
+	return n, err
+}
+
+func (b *progressBar) update(p analyzer.Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.line = progressBarText(p)
+	fmt.Fprint(b.w, "\r\x1b[K"+b.line)
+	if p.Done {
+		fmt.Fprintln(b.w)
+		b.line = ""
+	}
+}
+
+// progressBarText is progressText after a bar, which is left out if total is unknown
+func progressBarText(p analyzer.Progress) string {
+	if p.Total <= 0 {
+		return progressText(p)
+	}
+	filled := int(progressFraction(p) * progressBarWidth)
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", progressBarWidth-filled) + "] " + progressText(p)
+}
+
+// progressText is like "45.0% 45000/100000 keys, 1.20 MB, 3000 keys/s, ETA 18s"
+func progressText(p analyzer.Progress) string {
+	var parts []string
+	if p.Total > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f%% %d/%d keys", progressFraction(p)*100, p.Processed(), p.Total))
+	} else {
+		parts = append(parts, fmt.Sprintf("%d keys", p.Processed()))
+	}
+	parts = append(parts, report.FormatBytes(p.Bytes), fmt.Sprintf("%.0f keys/s", p.Rate))
+	if p.Dropped > 0 {
+		parts = append(parts, fmt.Sprintf("%d dropped", p.Dropped))
+	}
+	if p.Errors > 0 {
+		parts = append(parts, fmt.Sprintf("%d vanished", p.Errors))
+	}
+	switch {
+	case p.Done:
+		parts = append(parts, "done in "+formatSeconds(p.Elapsed))
+	case p.ETA >= 0:
+		parts = append(parts, "ETA "+formatSeconds(p.ETA))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// progressFraction is processed keys of total in [0, 1], keys added while scanning may exceed DBSIZE
+func progressFraction(p analyzer.Progress) float64 {
+	if p.Done {
+		return 1
+	}
+	f := float64(p.Processed()) / float64(p.Total)
+	if f > 1 {
+		f = 1
+	}
+	return f
+}
+
+func formatSeconds(seconds float64) string {
+	return (time.Duration(seconds * float64(time.Second))).Round(time.Second).String()
+}
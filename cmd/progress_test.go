@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/iccolo/rma/analyzer"
+)
+
+func TestProgressText(t *testing.T) {
+	p := analyzer.Progress{Analyzed: 40, Dropped: 5, Total: 100, Bytes: 2048, Rate: 15, ETA: 3.6}
+	if got, want := progressText(p), "45.0% 45/100 keys, 2.00 KB, 15 keys/s, 5 dropped, ETA 4s"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if got := progressBarText(p); !strings.HasPrefix(got, "[#############.................] ") {
+		t.Fatalf("unexpected bar %q", got)
+	}
+	unknown := analyzer.Progress{Analyzed: 7, ETA: -1}
+	if got, want := progressBarText(unknown), "7 keys, 0 B, 0 keys/s"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	buf := &bytes.Buffer{}
+	b := &progressBar{w: buf}
+	b.update(p)
+	_, _ = b.Write([]byte("log line\n"))
+	b.update(analyzer.Progress{Analyzed: 100, Total: 100, Done: true, Elapsed: 2})
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], "log line") || !strings.Contains(lines[1], "done in 2s") {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/report"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+// keys of terminal input
+const (
+	keyUp       = "up"
+	keyDown     = "down"
+	keyLeft     = "left"
+	keyRight    = "right"
+	keyPageUp   = "pgup"
+	keyPageDown = "pgdn"
+	keyHome     = "home"
+	keyEnd      = "end"
+	keyEnter    = "enter"
+	keyBack     = "backspace"
+	keyQuit     = "ctrl-c"
+)
+
+var escapeKeys = map[string]string{
+	"\x1b[A": keyUp, "\x1b[B": keyDown, "\x1b[C": keyRight, "\x1b[D": keyLeft,
+	"\x1bOA": keyUp, "\x1bOB": keyDown, "\x1bOC": keyRight, "\x1bOD": keyLeft,
+	"\x1b[5~": keyPageUp, "\x1b[6~": keyPageDown,
+	"\x1b[H": keyHome, "\x1b[F": keyEnd, "\x1b[1~": keyHome, "\x1b[4~": keyEnd,
+}
+
+// parseKeys split input read at once into keys, unknown escape sequences are dropped
+func parseKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		switch c := b[0]; {
+		case c == 0x1b:
+			n := 1
+			if len(b) > 1 && (b[1] == '[' || b[1] == 'O') {
+				n = 2
+				for n < len(b) && (b[n] < 0x40 || b[n] > 0x7e) {
+					n++
+				}
+				if n < len(b) {
+					n++
+				}
+			}
+			if key, ok := escapeKeys[string(b[:n])]; ok {
+				keys = append(keys, key)
+			}
+			b = b[n:]
+			continue
+		case c == '\r' || c == '\n':
+			keys = append(keys, keyEnter)
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, keyBack)
+		case c == 0x03:
+			keys = append(keys, keyQuit)
+		default:
+			keys = append(keys, string(c))
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+// terminal in raw mode set by stty, so that no dependency is needed
+type terminal struct {
+	state string // saved by stty -g
+	out   *bufio.Writer
+	winch chan os.Signal // resized, size is queried again
+	rows  int
+	cols  int
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func openTerminal() (*terminal, error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("stdin is not a terminal: %v", err)
+	}
+	if _, err = stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	t := &terminal{state: state, out: bufio.NewWriter(os.Stdout), winch: make(chan os.Signal, 1)}
+	t.querySize()
+	notifyResize(t.winch)
+	// alternate screen and hide cursor
+	t.out.WriteString("\x1b[?1049h\x1b[?25l")
+	t.out.Flush()
+	return t, nil
+}
+
+func (t *terminal) Close() {
+	signal.Stop(t.winch)
+	t.out.WriteString("\x1b[?25h\x1b[?1049l")
+	t.out.Flush()
+	_, _ = stty(t.state)
+}
+
+// querySize get rows and columns of terminal by stty, 24x80 if unknown
+func (t *terminal) querySize() {
+	t.rows, t.cols = 24, 80
+	out, err := stty("size")
+	if err == nil {
+		var rows, cols int
+		if _, err = fmt.Sscan(out, &rows, &cols); err == nil && rows > 0 && cols > 0 {
+			t.rows, t.cols = rows, cols
+		}
+	}
+}
+
+// draw lines from top left, every line is cut to cols
+func (t *terminal) draw(lines []string, cols int) {
+	t.out.WriteString("\x1b[H\x1b[2J")
+	for i, line := range lines {
+		if i > 0 {
+			t.out.WriteString("\r\n")
+		}
+		t.out.WriteString(cut(line, cols))
+	}
+	t.out.Flush()
+}
+
+// cut s to at most n runes, escape sequences of style are not counted
+func cut(s string, n int) string {
+	count := 0
+	inEscape := false
+	for i, r := range s {
+		switch {
+		case r == 0x1b:
+			inEscape = true
+		case inEscape:
+			inEscape = r < 0x40 || r == '['
+		default:
+			if count == n {
+				return s[:i] + "\x1b[0m"
+			}
+			count++
+		}
+	}
+	return s
+}
+
+// lastLine keep the last line written, which takes log output from the screen
+type lastLine struct {
+	mu   sync.Mutex
+	line string
+}
+
+func (l *lastLine) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if line := strings.TrimSpace(string(p)); line != "" {
+		l.line = line
+	}
+	return len(p), nil
+}
+
+func (l *lastLine) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.line
+}
+
+// orders of children in browser, all are descending
+var sortNames = []string{"size", "keys", "children"}
+
+// browser is an ncdu like view of snapshots, which are taken again on every draw while analyzing
+type browser struct {
+	a        *analyzer.Analyzer // reads values of keys, nil if keys can not be inspected
+	snapshot func() *analyzer.Snapshot
+	mode     escape.Mode
+	log      *lastLine
+
+	keyT    analyzer.KeyType
+	path    string   // key prefix of current node
+	parents []string // paths of ancestors
+	cursors []int    // cursors of ancestors
+	cursor  int
+	offset  int // first child shown
+	sortBy  int
+	panel   []string // inspection shown over children until any key, nil if hidden
+
+	current  *tree.Node
+	children []*tree.Node
+	listRows int
+}
+
+// runTUI browse snapshots in terminal until q, a is used to inspect keys and may be nil
+func runTUI(a *analyzer.Analyzer, snapshot func() *analyzer.Snapshot, mode escape.Mode) {
+	term, err := openTerminal()
+	if err != nil {
+		log.Fatalf("open terminal err:%v", err)
+	}
+	defer term.Close()
+	b := &browser{a: a, snapshot: snapshot, mode: mode, log: &lastLine{}, keyT: analyzer.KeyTypeAll}
+	log.SetOutput(b.log)
+	defer log.SetOutput(os.Stderr)
+
+	keys := make(chan string, 16)
+	go readKeys(os.Stdin, keys)
+	ticker := time.NewTicker(analyzer.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		term.draw(b.render(term.rows, term.cols), term.cols)
+		select {
+		case key, ok := <-keys:
+			if !ok || !b.handle(key) {
+				return
+			}
+		case <-term.winch:
+			term.querySize()
+		case <-ticker.C:
+		}
+	}
+}
+
+func readKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			keys <- key
+		}
+	}
+}
+
+// handle key, return false to quit
+func (b *browser) handle(key string) bool {
+	if b.panel != nil {
+		b.panel = nil
+		return key != keyQuit
+	}
+	switch key {
+	case "q", keyQuit:
+		return false
+	case keyUp, "k":
+		b.cursor--
+	case keyDown, "j":
+		b.cursor++
+	case keyPageUp:
+		b.cursor -= b.listRows
+	case keyPageDown:
+		b.cursor += b.listRows
+	case keyHome, "g":
+		b.cursor = 0
+	case keyEnd, "G":
+		b.cursor = len(b.children) - 1
+	case keyRight, keyEnter, "l":
+		if b.cursor < len(b.children) && !b.children[b.cursor].IsLeaf() {
+			b.parents = append(b.parents, b.path)
+			b.cursors = append(b.cursors, b.cursor)
+			b.path = b.children[b.cursor].Path()
+			b.cursor, b.offset = 0, 0
+		}
+	case keyLeft, keyBack, "h":
+		b.up()
+	case "s":
+		b.sortBy = (b.sortBy + 1) % len(sortNames)
+	case "t":
+		b.nextType()
+	case "i":
+		b.inspect()
+	}
+	return true
+}
+
+// up go to the parent of current node
+func (b *browser) up() {
+	if len(b.parents) == 0 {
+		return
+	}
+	last := len(b.parents) - 1
+	b.path, b.cursor = b.parents[last], b.cursors[last]
+	b.parents, b.cursors = b.parents[:last], b.cursors[:last]
+	b.offset = 0
+}
+
+// nextType switch to the next key type with keys, and back to the root
+func (b *browser) nextType() {
+	types := b.snapshot().GetKeyTypeStr()
+	if len(types) == 0 {
+		return
+	}
+	next := types[0]
+	for i, typeStr := range types {
+		if keyT, _ := analyzer.ParseKeyType(typeStr); keyT == b.keyT {
+			next = types[(i+1)%len(types)]
+		}
+	}
+	b.keyT, _ = analyzer.ParseKeyType(next)
+	b.path, b.parents, b.cursors = "", nil, nil
+	b.cursor, b.offset = 0, 0
+}
+
+// load current node and its sorted children from a new snapshot, nodes may be merged or folded since last load,
+// so go up until the current node exists
+func (b *browser) load(s *analyzer.Snapshot) {
+	t := s.Tree(b.keyT)
+	for b.current = t.Find(b.path); b.current == nil; b.current = t.Find(b.path) {
+		if len(b.parents) == 0 {
+			b.path = ""
+			continue
+		}
+		b.up()
+	}
+	b.children = b.current.Children()
+	sort.SliceStable(b.children, func(i, j int) bool {
+		x, y := b.children[i], b.children[j]
+		switch b.sortBy {
+		case 1:
+			return x.KeyNum > y.KeyNum
+		case 2:
+			return x.ChildNum > y.ChildNum
+		default:
+			return x.Size > y.Size
+		}
+	})
+}
+
+func (b *browser) render(rows, cols int) []string {
+	s := b.snapshot()
+	b.load(s)
+	typeStr := analyzer.KeyTypeAllStr
+	if b.keyT != analyzer.KeyTypeAll {
+		typeStr = analyzer.KeyTypeToTypeStr[b.keyT]
+	}
+	state := "complete"
+	if !s.Complete {
+		state = "analyzing..."
+		if b.a != nil {
+			state = "analyzing " + progressText(b.a.Progress())
+		}
+	}
+	lines := []string{
+		fmt.Sprintf("\x1b[7m rma  type: %s  sort: %s  %s%s\x1b[0m", typeStr, sortNames[b.sortBy], state,
+			strings.Repeat(" ", cols)),
+		fmt.Sprintf(" %s  %s  %d keys", b.title(), report.FormatBytes(b.current.Size), b.current.KeyNum),
+		strings.Repeat("-", cols),
+	}
+	b.listRows = rows - len(lines) - 2
+	if b.listRows < 1 {
+		b.listRows = 1
+	}
+
+	if b.panel != nil {
+		lines = append(lines, b.panel...)
+		for len(lines) < rows-2 {
+			lines = append(lines, "")
+		}
+		lines = lines[:rows-2]
+		return append(lines, "", " press any key to return")
+	}
+
+	if b.cursor >= len(b.children) {
+		b.cursor = len(b.children) - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	}
+	if b.cursor >= b.offset+b.listRows {
+		b.offset = b.cursor - b.listRows + 1
+	}
+	for i := b.offset; i < len(b.children) && i < b.offset+b.listRows; i++ {
+		line := b.row(b.children[i], cols)
+		if i == b.cursor {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) < rows-2 {
+		lines = append(lines, "")
+	}
+	return append(lines, " "+b.log.String(),
+		"\x1b[7m ↑↓ move  →/enter open  ←/backspace up  s sort  t type  i inspect  q quit \x1b[0m")
+}
+
+// title is the escaped path of current node
+func (b *browser) title() string {
+	if b.path == "" {
+		return "/"
+	}
+	return b.mode.Escape(b.path)
+}
+
+// row of child like "  1.50 MB  12.3% [###       ]     100 keys  user:"
+func (b *browser) row(n *tree.Node, cols int) string {
+	share := 0.0
+	if b.current.Size > 0 {
+		share = float64(n.Size) / float64(b.current.Size)
+	}
+	bar := strings.Repeat("#", int(share*10+0.5))
+	name := b.mode.Escape(n.Segment)
+	if !n.IsLeaf() {
+		name += "/"
+	}
+	line := fmt.Sprintf(" %10s %6.1f%% [%-10s] %10d keys  %s", report.FormatBytes(n.Size), share*100, bar, n.KeyNum, name)
+	if pad := cols - len([]rune(line)); pad > 0 {
+		line += strings.Repeat(" ", pad)
+	}
+	return line
+}
+
+// inspectKeyNum is the number of keys or elements shown by inspection
+const inspectKeyNum = 10
+
+// inspect show value of the key under cursor, or the biggest keys under the prefix
+func (b *browser) inspect() {
+	if b.cursor >= len(b.children) {
+		return
+	}
+	n := b.children[b.cursor]
+	path := n.Path()
+	if !n.IsLeaf() || n.KeyNum != 1 || n.Segment == tree.OtherSegment {
+		b.panel = []string{fmt.Sprintf(" biggest keys under %s:", b.mode.Escape(path)), ""}
+		for _, leaf := range biggestLeaves(n, inspectKeyNum) {
+			b.panel = append(b.panel, fmt.Sprintf(" %10s  %s", report.FormatBytes(leaf.Size), b.mode.Escape(leaf.Path())))
+		}
+		return
+	}
+	if b.a == nil {
+		b.panel = []string{" values can not be read from merged snapshots"}
+		return
+	}
+	b.panel = b.keyValue(path)
+}
+
+// biggestLeaves return at most num leaves under n by size desc
+func biggestLeaves(n *tree.Node, num int) []*tree.Node {
+	var leaves []*tree.Node
+	for it := n.Leaves(); it.Next(); {
+		leaf := it.Node()
+		if len(leaves) == num && leaf.Size <= leaves[num-1].Size {
+			continue
+		}
+		i := sort.Search(len(leaves), func(i int) bool { return leaves[i].Size < leaf.Size })
+		leaves = append(leaves, nil)
+		copy(leaves[i+1:], leaves[i:])
+		leaves[i] = leaf
+		if len(leaves) > num {
+			leaves = leaves[:num]
+		}
+	}
+	return leaves
+}
+
+// keyValue read key by GetKeyValue as lines, failures to connect are shown instead of panic
+func (b *browser) keyValue(key string) (lines []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			lines = []string{fmt.Sprintf(" read %s err:%v", b.mode.Escape(key), r)}
+		}
+	}()
+	kv, err := b.a.GetKeyValue(key, inspectKeyNum)
+	lines = []string{" key:  " + kv.Key, " type: " + kv.Type, " ttl:  " + strconv.FormatInt(kv.TTL, 10)}
+	if err != nil {
+		return append(lines, " err:  "+err.Error())
+	}
+	lines = append(lines, " value:")
+	switch value := kv.Value.(type) {
+	case string:
+		lines = append(lines, "   "+value)
+	case []string:
+		for _, v := range value {
+			lines = append(lines, "   "+v)
+		}
+	case map[string]string:
+		fields := make([]string, 0, len(value))
+		for field := range value {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			lines = append(lines, "   "+field+": "+value[field])
+		}
+	case []analyzer.ZSetItem:
+		for _, item := range value {
+			lines = append(lines, fmt.Sprintf("   %s %g", item.Member, item.Score))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,9 @@
+package main
+
+import (
+	"os"
+)
+
+// notifyResize do nothing since there is no SIGWINCH on windows, the size is queried only once
+func notifyResize(c chan os.Signal) {
+}
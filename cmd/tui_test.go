@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/escape"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("\x1b[Aj\r\x1b[5~\x7f\x03\x1b[Zx\x1b"))
+	want := []string{keyUp, "j", keyEnter, keyPageUp, keyBack, keyQuit, "x"} // unknown and lone escapes are dropped
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestCut(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"abcdef", 3, "abc\x1b[0m"},
+		{"ab", 5, "ab"},
+		{"\x1b[7mabc\x1b[0m", 2, "\x1b[7mab\x1b[0m"}, // style is not counted
+		{"用户名", 2, "用户\x1b[0m"},
+	} {
+		if got := cut(c.s, c.n); got != c.want {
+			t.Errorf("cut(%q, %d) expected %q, got %q", c.s, c.n, c.want, got)
+		}
+	}
+}
+
+func TestBiggestLeaves(t *testing.T) {
+	k := analyzer.NewKeyTypeTree(tree.ByteRules([]byte(":")))
+	for key, size := range map[string]int64{"a:1": 5, "a:2": 30, "b:1": 20, "b:2": 1, "c": 10} {
+		k.AddKey(&analyzer.KeyInfo{Key: key, KeyT: analyzer.KeyTypeString, Size: size})
+	}
+	k.Finish()
+	var got []string
+	for _, leaf := range biggestLeaves(k.Snapshot().Tree(analyzer.KeyTypeString).Find(""), 3) {
+		got = append(got, leaf.Path())
+	}
+	if want := []string{"a:2", "b:1", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestBrowser(t *testing.T) {
+	defer func(interval time.Duration) { analyzer.SnapshotInterval = interval }(analyzer.SnapshotInterval)
+	analyzer.SnapshotInterval = 0
+	k := analyzer.NewKeyTypeTree(tree.ByteRules([]byte(":")))
+	for key, size := range map[string]int64{"user:1:a": 10, "user:1:b": 10, "user:2:a": 5, "user:2:b": 5,
+		"user:3:a": 1, "user:3:b": 1, "user:3:c": 1, "order:1": 3} {
+		k.AddKey(&analyzer.KeyInfo{Key: key, KeyT: analyzer.KeyTypeString, Size: size})
+	}
+	b := &browser{snapshot: k.Snapshot, mode: escape.Auto, log: &lastLine{}, keyT: analyzer.KeyTypeAll}
+	b.render(10, 80)
+	if len(b.children) != 2 || b.children[0].Path() != "user:" {
+		t.Fatalf("Expected user: and order:1 sorted by size, got %v", b.children)
+	}
+
+	// cursor is clamped to children
+	b.handle(keyDown)
+	b.handle(keyDown)
+	b.render(10, 80)
+	if b.cursor != 1 {
+		t.Errorf("Expected cursor clamped to 1, got %d", b.cursor)
+	}
+	b.handle(keyUp)
+	b.handle(keyUp)
+	b.render(10, 80)
+	if b.cursor != 0 {
+		t.Errorf("Expected cursor clamped to 0, got %d", b.cursor)
+	}
+
+	// open user: and user:1:, then go up
+	b.handle(keyEnter)
+	b.render(10, 80)
+	b.handle(keyEnter)
+	b.render(10, 80)
+	if b.path != "user:1:" || len(b.children) != 2 {
+		t.Fatalf("Expected 2 children of user:1:, got %q %v", b.path, b.children)
+	}
+	b.handle(keyLeft)
+	b.render(10, 80)
+	if b.path != "user:" || b.cursor != 0 {
+		t.Errorf("Expected user: with cursor 0, got %q %d", b.path, b.cursor)
+	}
+
+	// user:1: is folded into (other) by the next snapshot, so the browser goes up to user:
+	b.handle(keyHome)
+	b.handle(keyEnter)
+	b.render(10, 80)
+	if b.path != "user:1:" {
+		t.Fatalf("Expected user:1:, got %q", b.path)
+	}
+	k.SetFold(0, 3)
+	b.render(10, 80)
+	if b.path != "user:" || len(b.parents) != 1 || len(b.children) != 2 || b.children[0].Segment != tree.OtherSegment {
+		t.Errorf("Expected user: with %s and 3:, got %q %v", tree.OtherSegment, b.path, b.children)
+	}
+	if b.handle("q") {
+		t.Errorf("Expected q to quit")
+	}
+}
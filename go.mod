@@ -2,4 +2,8 @@ module github.com/iccolo/rma
 
 go 1.17
 
-require github.com/gomodule/redigo v1.8.9
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/gomodule/redigo v1.8.9
+	gopkg.in/yaml.v3 v3.0.1
+)
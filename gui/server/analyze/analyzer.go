@@ -5,11 +5,9 @@ import (
 	"fmt"
 	"log"
 	"sort"
-	"strconv"
 	"sync"
 	"time"
 
-	"github.com/gomodule/redigo/redis"
 	"github.com/iccolo/rma/analyzer"
 	"github.com/iccolo/rma/analyzer/tree"
 )
@@ -20,6 +18,7 @@ type Handler interface {
 	GetKeyTypes(host string) ([]string, error)
 	Expand(host, keyType, keyPrefix string, numLimit int64, sort SortVar) ([]*NodeInfo, error)
 	GetKeyInfo(host, key string, limit int) (*RedisValue, error)
+	GetProgress(host string) (*analyzer.Progress, error)
 }
 
 type handler struct {
@@ -44,10 +43,12 @@ type instance struct {
 }
 
 type InstanceStatus struct {
-	Host             string `json:"host"`
-	AnalyzeStartTime string `json:"analyze_start_time"`
-	AnalyzeEndTime   string `json:"analyze_end_time"`
-	IsFinish         bool   `json:"is_finish"`
+	Host             string            `json:"host"`
+	AnalyzeStartTime string            `json:"analyze_start_time"`
+	AnalyzeEndTime   string            `json:"analyze_end_time"`
+	IsFinish         bool              `json:"is_finish"`
+	FilteredKeyNum   int64             `json:"filtered_key_num"`
+	Progress         analyzer.Progress `json:"progress"`
 }
 
 func (h *handler) GetInstanceList() []*InstanceStatus {
@@ -63,6 +64,8 @@ func (h *handler) GetInstanceList() []*InstanceStatus {
 			AnalyzeStartTime: instance.AnalyzeStartTime.Format("2006-01-02 15:04:05"),
 			AnalyzeEndTime:   instance.AnalyzeEndTime.Format("2006-01-02 15:04:05"),
 			IsFinish:         instance.IsFinish,
+			FilteredKeyNum:   instance.Analyzer.FilteredKeyNum(),
+			Progress:         instance.Analyzer.Progress(),
 		})
 	}
 	return list
@@ -99,6 +102,17 @@ func (h *handler) GetKeyTypes(host string) ([]string, error) {
 	return instance.Tree.GetKeyTypeStr(), nil
 }
 
+func (h *handler) GetProgress(host string) (*analyzer.Progress, error) {
+	h.mu.Lock()
+	instance, ok := h.instances[host]
+	h.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("host:%v not exits", host)
+	}
+	p := instance.Analyzer.Progress()
+	return &p, nil
+}
+
 func (h *handler) Expand(host, keyType, keyPrefix string, numLimit int64, sortVar SortVar) ([]*NodeInfo, error) {
 	h.mu.Lock()
 	instance, ok := h.instances[host]
@@ -106,11 +120,22 @@ func (h *handler) Expand(host, keyType, keyPrefix string, numLimit int64, sortVa
 	if !ok {
 		return nil, fmt.Errorf("host:%v not exits", host)
 	}
-	keyT, ok := analyzer.KeyTypeStrToType[keyType]
+	keyT, ok := analyzer.ParseKeyType(keyType)
 	if !ok {
 		return nil, fmt.Errorf("req key type:%v not exist", keyType)
 	}
-	nodes := instance.Tree.Expand(keyPrefix, keyT)
+	// key prefix is the escaped path of node, or empty for root
+	mode := instance.Analyzer.EscapeMode()
+	if keyPrefix != "" {
+		var err error
+		if keyPrefix, err = mode.Unescape(keyPrefix); err != nil {
+			return nil, fmt.Errorf("unescape key prefix:%v", err)
+		}
+	}
+	// nodes and sampling come from the same snapshot while the analyzer is running
+	snapshot := instance.Tree.Snapshot()
+	nodes := snapshot.Expand(keyPrefix, keyT)
+	sampling := snapshot.GetSampling()
 
 	sortedNode := &SortedNode{
 		Nodes:   make([]*tree.Node, 0, numLimit),
@@ -127,16 +152,25 @@ func (h *handler) Expand(host, keyType, keyPrefix string, numLimit int64, sortVa
 	layer := make([]*NodeInfo, 0, len(sortedNode.Nodes))
 	for i := sortedNode.Len() - 1; i >= 0; i-- {
 		node := sortedNode.Nodes[i]
-		seg := node.Segment
-		if len(node.Child) == 0 {
-			seg = keyPrefix + seg
+		path := mode.Escape(keyPrefix + node.Segment)
+		seg := mode.Escape(node.Segment)
+		if node.ChildNum == 0 {
+			seg = path
 		}
-		layer = append(layer, &NodeInfo{
+		info := &NodeInfo{
 			Segment:   seg,
+			Path:      path,
 			KeyNum:    node.KeyNum,
 			TotalSize: node.Size,
-			ChildNum:  int32(len(node.Child)),
-		})
+			ChildNum:  int32(node.ChildNum),
+		}
+		if sampling != nil {
+			info.Estimate = sampling.Estimate(node)
+		}
+		if keyT == analyzer.KeyTypeAll {
+			info.Types = node.Sources()
+		}
+		layer = append(layer, info)
 	}
 	return layer, nil
 }
@@ -148,106 +182,27 @@ func (h *handler) GetKeyInfo(host, key string, limit int) (*RedisValue, error) {
 	if !ok {
 		return nil, fmt.Errorf("host:%v not exits", host)
 	}
-	conn := instance.Analyzer.Dial()
-	defer conn.Close()
-
-	res := &RedisValue{
-		Key: key,
-	}
-	// 查看 key 的类型
-	keyType, err := redis.String(conn.Do("TYPE", key))
-	if err != nil {
-		return res, fmt.Errorf("failed to get key type: %s", err)
-	}
-	res.Type = keyType
-
-	// 获取 key 的 TTL
-	ttl, err := redis.Int64(conn.Do("TTL", key))
+	// keys and values are escaped in response
+	key, err := instance.Analyzer.EscapeMode().Unescape(key)
 	if err != nil {
-		return res, fmt.Errorf("failed to get key ttl: %s", err)
+		return nil, fmt.Errorf("unescape key:%v", err)
 	}
-	res.TTL = ttl
-
-	// 根据 key 的类型获取对应的值
-	switch keyType {
-	case "string":
-		// 获取 string 类型的值
-		result, err := redis.String(conn.Do("GET", key))
-		if err != nil {
-			return res, fmt.Errorf("failed to get string result: %s", err)
-		}
-		res.Value = result
-	case "list":
-		// 获取 list 类型的值
-		results, err := redis.Strings(conn.Do("LRANGE", key, 0, limit-1))
-		if err != nil {
-			return res, fmt.Errorf("failed to get list results: %s", err)
-		}
-		res.Value = results
-	case "hash":
-		// 获取 hash 类型的值
-		results, err := redis.Values(conn.Do("HSCAN", key, 0, "COUNT", limit))
-		if err != nil {
-			return res, fmt.Errorf("failed to get hash results: %s", err)
-		}
-		values, err := redis.StringMap(results[1], err)
-		if err != nil {
-			return res, fmt.Errorf("failed to get hash results: %s", err)
-		}
-		res.Value = values
-	case "set":
-		// 获取 set 类型的值
-		results, err := redis.Values(conn.Do("SSCAN", key, 0, "COUNT", limit))
-		if err != nil {
-			return res, fmt.Errorf("failed to get set results: %s", err)
-		}
-		values, err := redis.Strings(results[1], err)
-		if err != nil {
-			return res, fmt.Errorf("failed to get set results: %s", err)
-		}
-		res.Value = values
-	case "zset":
-		// 获取 zset 类型的值
-		results, err := redis.Values(conn.Do("ZRANGE", key, 0, limit-1, "WITHSCORES"))
-		if err != nil {
-			return res, fmt.Errorf("failed to get zset results: %s", err)
-		}
-		values := make([]ZSetItem, 0, len(results)/2)
-		for i := 0; i < len(results); i += 2 {
-			score, err := strconv.ParseFloat(string(results[i+1].([]byte)), 64)
-			if err != nil {
-				return res, fmt.Errorf("failed to parse zset score: %s", err)
-			}
-			values = append(values, ZSetItem{
-				Member: string(results[i].([]byte)),
-				Score:  score,
-			})
-		}
-		res.Value = values
-	default:
-		return res, fmt.Errorf("unsupported redis key type '%s'", keyType)
-	}
-
-	return res, nil
+	return instance.Analyzer.GetKeyValue(key, limit)
 }
 
-type RedisValue struct {
-	Key   string      `json:"key"`
-	Type  string      `json:"type"`
-	TTL   int64       `json:"ttl"`
-	Value interface{} `json:"value"`
-}
+type RedisValue = analyzer.KeyValue
 
-type ZSetItem struct {
-	Member string  `json:"member"`
-	Score  float64 `json:"score"`
-}
+type ZSetItem = analyzer.ZSetItem
 
 type NodeInfo struct {
 	Segment   string `json:"segment"`
+	Path      string `json:"path"` // escaped key prefix of node
 	KeyNum    int64  `json:"key_num"`
 	TotalSize int64  `json:"total_size"`
 	ChildNum  int32  `json:"child_num"`
+
+	Estimate *tree.Estimate    `json:"estimate,omitempty"` // only for sampling analysis
+	Types    []tree.SourceStat `json:"types,omitempty"`    // size and key num by type, only for all types view
 }
 
 type SortVar int32
@@ -271,7 +226,7 @@ func (e *SortedNode) Less(i, j int) bool {
 	case SortVarKeyNum:
 		return n[i].KeyNum < n[j].KeyNum // KeyNum 小优先
 	case SortVarChildNum:
-		return len(n[i].Child) < len(n[i].Child) // Child 数量少优先
+		return n[i].ChildNum < n[j].ChildNum // Child 数量少优先
 	default:
 		return n[i].Segment > n[j].Segment
 	}
@@ -0,0 +1,29 @@
+package analyze
+
+import (
+	"testing"
+
+	"github.com/iccolo/rma/analyzer"
+	"github.com/iccolo/rma/analyzer/tree"
+)
+
+func TestExpand(t *testing.T) {
+	k := analyzer.NewKeyTypeTree(tree.ByteRules([]byte(":")))
+	k.AddKey(&analyzer.KeyInfo{Key: "user:\x96:name", KeyT: analyzer.KeyTypeString, Size: 10})
+	k.AddKey(&analyzer.KeyInfo{Key: "user:1:name", KeyT: analyzer.KeyTypeString, Size: 20})
+	k.Finish()
+	h := &handler{instances: map[string]*instance{
+		"127.0.0.1": {Host: "127.0.0.1", Analyzer: &analyzer.Analyzer{Escape: "quote"}, Tree: k},
+	}}
+
+	// root is expanded by the empty prefix, which is not a quoted string
+	root, err := h.Expand("127.0.0.1", "string", "", 10, SortVarTotalSize)
+	if err != nil || len(root) != 1 || root[0].Path != `"user:"` || root[0].KeyNum != 2 {
+		t.Fatalf("Unexpected root %+v %v", root, err)
+	}
+	// children are expanded by escaped paths
+	children, err := h.Expand("127.0.0.1", "string", root[0].Path, 10, SortVarTotalSize)
+	if err != nil || len(children) != 2 || children[0].Path != `"user:1:name"` || children[1].Path != `"user:\x96:name"` {
+		t.Errorf("Unexpected children %+v %v", children, err)
+	}
+}